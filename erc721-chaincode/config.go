@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Define key names for options
+const configKey = "config"
+
+// DefaultAdminMSP administers the config until SetConfig names another MSP
+const DefaultAdminMSP = "Org1MSP"
+
+// ChaincodeConfig holds the limits of the chaincode, in the same format as the config of the key-value chaincode.
+// The two configs are deliberately separate, hlf-erc721.sh deploys this chaincode alone on its own channel.
+// A limit of 0 is no limit, MaxJSONDepth counts nested objects and arrays so a flat object has depth 1.
+// A tokenId must fully match one of the KeyPatterns, Go regular expressions, empty KeyPatterns allow every tokenId.
+type ChaincodeConfig struct {
+	AdminMSP      string   `json:"adminMSP"`
+	MaxAttributes int      `json:"maxAttributes"`
+	MaxValueBytes int      `json:"maxValueBytes"`
+	MaxJSONDepth  int      `json:"maxJSONDepth"`
+	KeyPatterns   []string `json:"keyPatterns"`
+	TxID          string   `json:"txId"`
+}
+
+/**
+ * Get the current config.
+ *
+ * @param {Context} ctx the transaction context
+ * @returns {Object} Return the config, the defaults keep the former limit of 100 TokenData attributes
+ */
+func (sc *ERC721Contract) GetConfig(ctx contractapi.TransactionContextInterface) (*ChaincodeConfig, error) {
+	err := _requireConfigAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return _readConfig(ctx)
+}
+
+/**
+ * Change the config, the limits apply to tokens minted afterwards.
+ *
+ * @param {Context} ctx the transaction context
+ * @param {String} configJSON The fields to change, the others keep their value
+ */
+func (sc *ERC721Contract) SetConfig(ctx contractapi.TransactionContextInterface, configJSON string) error {
+	err := _requireConfigAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	config, err := _readConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal([]byte(configJSON), config)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal([]byte(configJSON), config) in SetConfig: %v", err)
+		return fmt.Errorf("config is not valid JSON: %v", err)
+	}
+	if config.AdminMSP == "" {
+		return errors.New("adminMSP must not be empty")
+	}
+	if config.MaxAttributes < 0 || config.MaxValueBytes < 0 || config.MaxJSONDepth < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if config.KeyPatterns == nil {
+		config.KeyPatterns = []string{}
+	}
+	for _, pattern := range config.KeyPatterns {
+		_, err = _compileKeyPattern(pattern)
+		if err != nil {
+			return err
+		}
+	}
+	config.TxID = ctx.GetStub().GetTxID()
+
+	var configBytes []byte
+	configBytes, _ = json.Marshal(config)
+
+	return ctx.GetStub().PutState(configKey, configBytes)
+}
+
+func _requireConfigAdmin(ctx contractapi.TransactionContextInterface) error {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get MSPID: %v", err)
+	}
+	config, err := _readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if clientMSPID != config.AdminMSP {
+		return errors.New(`client is not authorized to change the config`)
+	}
+
+	return nil
+}
+
+func _readConfig(ctx contractapi.TransactionContextInterface) (*ChaincodeConfig, error) {
+	configBytes, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	if configBytes == nil {
+		return &ChaincodeConfig{
+			AdminMSP:      DefaultAdminMSP,
+			MaxAttributes: 100,
+			KeyPatterns:   []string{},
+		}, nil
+	}
+
+	var config ChaincodeConfig
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// _checkTokenLimits checks a token to be minted, and the JSON it was decoded from, against the config
+func _checkTokenLimits(ctx contractapi.TransactionContextInterface, nft *NFT, nftStr string) error {
+	config, err := _readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if config.MaxAttributes > 0 && len(nft.TokenData) > config.MaxAttributes {
+		return fmt.Errorf("len(nft.TokenData) > %d, the maxAttributes of the config", config.MaxAttributes)
+	}
+	if len(config.KeyPatterns) > 0 {
+		matched := false
+		for _, pattern := range config.KeyPatterns {
+			keyPattern, err := _compileKeyPattern(pattern)
+			if err != nil {
+				return err
+			}
+			matched = matched || keyPattern.MatchString(nft.TokenId)
+		}
+		if !matched {
+			return fmt.Errorf("tokenId %s does not match any of the key patterns %s", nft.TokenId, strings.Join(config.KeyPatterns, ", "))
+		}
+	}
+	if config.MaxJSONDepth == 0 && config.MaxValueBytes == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(nftStr))
+	decoder.UseNumber()
+	var decoded interface{}
+	err = decoder.Decode(&decoded)
+	if err != nil {
+		return err
+	}
+
+	return _checkJSONValue(config, decoded, 0)
+}
+
+// _checkJSONValue checks a value nested depth levels deep, visiting object keys in order
+// so that every peer reports the same violation
+func _checkJSONValue(config *ChaincodeConfig, value interface{}, depth int) error {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if config.MaxJSONDepth > 0 && depth >= config.MaxJSONDepth {
+			return fmt.Errorf("token is nested deeper than maxJSONDepth %d", config.MaxJSONDepth)
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			err := _checkJSONValue(config, value[key], depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if config.MaxJSONDepth > 0 && depth >= config.MaxJSONDepth {
+			return fmt.Errorf("token is nested deeper than maxJSONDepth %d", config.MaxJSONDepth)
+		}
+		for _, element := range value {
+			err := _checkJSONValue(config, element, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	case string:
+		if config.MaxValueBytes > 0 && len(value) > config.MaxValueBytes {
+			return fmt.Errorf("token has a string of %d bytes, more than maxValueBytes %d", len(value), config.MaxValueBytes)
+		}
+	}
+
+	return nil
+}
+
+func _compileKeyPattern(pattern string) (*regexp.Regexp, error) {
+	keyPattern, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("key pattern %s is not a valid regular expression: %v", pattern, err)
+	}
+
+	return keyPattern, nil
+}
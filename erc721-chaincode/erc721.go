@@ -15,7 +15,6 @@ const approvalPrefix = "approval"
 // Define key names for options
 const nameKey = "name"
 const symbolKey = "symbol"
-const TokenDataLen = 100
 
 // ERC721Contract contract for handling writing and reading from the world state
 type ERC721Contract struct {
@@ -439,8 +438,8 @@ func (sc *ERC721Contract) MintWithTokenURI(ctx contractapi.TransactionContextInt
 		return NFT{}, err
 	}
 
-	if len(nft.TokenData) > TokenDataLen {
-		err = errors.New("len(recordData.TokenData) > (TokenDataLen=100),len is must <= 100")
+	err = _checkTokenLimits(ctx, &nft, nftStr)
+	if err != nil {
 		log.Print(err)
 		return NFT{}, err
 	}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define key names for settings
+const accessPolicyKey = "accessPolicy"
+
+// Operations an AccessPolicy grants
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+// AttributeCondition requires an attribute in the client's X.509 certificate, as issued by the Fabric CA.
+// An empty Value only requires the attribute to be present.
+type AttributeCondition struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty" metadata:",optional"`
+}
+
+// AccessPolicy lists the certificate attributes a client needs to read or write records, every condition
+// of an operation must hold. An operation without conditions is open to every client.
+type AccessPolicy struct {
+	Read  []AttributeCondition `json:"read"`
+	Write []AttributeCondition `json:"write"`
+}
+
+// SetAccessPolicy sets the access policy of the records outside any namespace, namespaces set theirs
+// in their settings. policyJSON is e.g. {"read":[{"name":"department","value":"logistics"}],"write":[{"name":"role","value":"writer"}]},
+// an empty object opens the records to every client again.
+func (sc *KeyValueContract) SetAccessPolicy(ctx contractapi.TransactionContextInterface, policyJSON string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	var policy AccessPolicy
+	err = json.Unmarshal([]byte(policyJSON), &policy)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal([]byte(policyJSON), &policy) in SetAccessPolicy: %v", err)
+		return fmt.Errorf("access policy is not valid JSON: %v", err)
+	}
+	err = validateAccessPolicy(&policy)
+	if err != nil {
+		return err
+	}
+
+	policyKey, err := ctx.GetStub().CreateCompositeKey(settingPrefix, []string{accessPolicyKey})
+	if err != nil {
+		return err
+	}
+	var policyBytes []byte
+	policyBytes, _ = json.Marshal(policy)
+
+	return ctx.GetStub().PutState(policyKey, policyBytes)
+}
+
+// GetAccessPolicy returns the access policy of the records outside any namespace
+func (sc *KeyValueContract) GetAccessPolicy(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+	return readAccessPolicy(ctx)
+}
+
+func readAccessPolicy(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+	policyKey, err := ctx.GetStub().CreateCompositeKey(settingPrefix, []string{accessPolicyKey})
+	if err != nil {
+		return nil, err
+	}
+	policyBytes, err := ctx.GetStub().GetState(policyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy: %v", err)
+	}
+	if policyBytes == nil {
+		return &AccessPolicy{Read: []AttributeCondition{}, Write: []AttributeCondition{}}, nil
+	}
+
+	var policy AccessPolicy
+	err = json.Unmarshal(policyBytes, &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// checkAccessPolicy fails unless the client's certificate attributes satisfy the policy of namespace for operation.
+// The records outside any namespace follow the policy set by SetAccessPolicy.
+func checkAccessPolicy(ctx contractapi.TransactionContextInterface, namespace string, operation string) error {
+	policy, err := namespaceAccessPolicy(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return nil
+	}
+
+	conditions := policy.Read
+	if operation == AccessWrite {
+		conditions = policy.Write
+	}
+	for _, condition := range conditions {
+		err = checkAttributeCondition(ctx, condition)
+		if err != nil {
+			if namespace == "" {
+				return fmt.Errorf("client is not allowed to %s records: %v", operation, err)
+			}
+			return fmt.Errorf("client is not allowed to %s namespace %s: %v", operation, namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// filterReadable drops the records of namespaces whose read policy the client does not satisfy
+func filterReadable(ctx contractapi.TransactionContextInterface, records []RecordData) []RecordData {
+	readable := map[string]bool{}
+	filtered := []RecordData{}
+	for _, recordData := range records {
+		allowed, checked := readable[recordData.Namespace]
+		if !checked {
+			allowed = checkAccessPolicy(ctx, recordData.Namespace, AccessRead) == nil
+			readable[recordData.Namespace] = allowed
+		}
+		if allowed {
+			filtered = append(filtered, recordData)
+		}
+	}
+
+	return filtered
+}
+
+func namespaceAccessPolicy(ctx contractapi.TransactionContextInterface, namespace string) (*AccessPolicy, error) {
+	if namespace == "" {
+		return readAccessPolicy(ctx)
+	}
+
+	settings, err := readNamespaceSettings(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, nil
+	}
+
+	return settings.Access, nil
+}
+
+func checkAttributeCondition(ctx contractapi.TransactionContextInterface, condition AttributeCondition) error {
+	if condition.Value != "" {
+		return ctx.GetClientIdentity().AssertAttributeValue(condition.Name, condition.Value)
+	}
+
+	_, found, err := ctx.GetClientIdentity().GetAttributeValue(condition.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read attribute %s: %v", condition.Name, err)
+	}
+	if !found {
+		return fmt.Errorf("attribute '%s' was not found", condition.Name)
+	}
+
+	return nil
+}
+
+// validateAccessPolicy rejects conditions without an attribute name and fills in missing condition lists
+func validateAccessPolicy(policy *AccessPolicy) error {
+	if policy.Read == nil {
+		policy.Read = []AttributeCondition{}
+	}
+	if policy.Write == nil {
+		policy.Write = []AttributeCondition{}
+	}
+	for _, conditions := range [][]AttributeCondition{policy.Read, policy.Write} {
+		for _, condition := range conditions {
+			if condition.Name == "" {
+				return errors.New("an attribute condition needs the name of the attribute")
+			}
+		}
+	}
+
+	return nil
+}
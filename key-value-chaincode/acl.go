@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Principal kinds accepted by GrantAccess and RevokeAccess
+const (
+	PrincipalIdentity = "identity"
+	PrincipalMSP      = "msp"
+)
+
+// RecordACL lists the client identities and MSPs that may modify a record besides its owner
+type RecordACL struct {
+	Identities []string `json:"identities"`
+	MSPs       []string `json:"msps"`
+}
+
+// GrantAccess lets a client identity or every member of an MSP modify the record at key.
+// kind is identity or msp, only the owner may grant access.
+func (sc *KeyValueContract) GrantAccess(ctx contractapi.TransactionContextInterface, key string, kind string, principal string) error {
+	recordData, err := readOwnedRecord(ctx, key)
+	if err != nil {
+		return err
+	}
+	if principal == "" {
+		return fmt.Errorf("principal must not be empty")
+	}
+
+	acl := recordData.ACL
+	if acl == nil {
+		acl = &RecordACL{Identities: []string{}, MSPs: []string{}}
+	}
+	switch kind {
+	case PrincipalIdentity:
+		acl.Identities = addPrincipal(acl.Identities, principal)
+	case PrincipalMSP:
+		acl.MSPs = addPrincipal(acl.MSPs, principal)
+	default:
+		return fmt.Errorf("unknown principal kind %s, must be %s or %s", kind, PrincipalIdentity, PrincipalMSP)
+	}
+	recordData.ACL = acl
+
+	return storeRecordChange(ctx, key, recordData, "acl")
+}
+
+// RevokeAccess removes a client identity or an MSP from the ACL of the record at key
+func (sc *KeyValueContract) RevokeAccess(ctx contractapi.TransactionContextInterface, key string, kind string, principal string) error {
+	recordData, err := readOwnedRecord(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	acl := recordData.ACL
+	if acl == nil {
+		acl = &RecordACL{Identities: []string{}, MSPs: []string{}}
+	}
+	switch kind {
+	case PrincipalIdentity:
+		acl.Identities = removePrincipal(acl.Identities, principal)
+	case PrincipalMSP:
+		acl.MSPs = removePrincipal(acl.MSPs, principal)
+	default:
+		return fmt.Errorf("unknown principal kind %s, must be %s or %s", kind, PrincipalIdentity, PrincipalMSP)
+	}
+	recordData.ACL = acl
+	if len(acl.Identities) == 0 && len(acl.MSPs) == 0 {
+		recordData.ACL = nil
+	}
+
+	return storeRecordChange(ctx, key, recordData, "acl")
+}
+
+// TransferOwnership hands the record at key to another client identity, the ACL is kept
+func (sc *KeyValueContract) TransferOwnership(ctx contractapi.TransactionContextInterface, key string, newOwner string, newOwnerMSP string) error {
+	recordData, err := readOwnedRecord(ctx, key)
+	if err != nil {
+		return err
+	}
+	if newOwner == "" || newOwnerMSP == "" {
+		return fmt.Errorf("new owner and new owner MSP must not be empty")
+	}
+
+	recordData.Owner = newOwner
+	recordData.OwnerMSP = newOwnerMSP
+
+	return storeRecordChange(ctx, key, recordData, "owner", "ownerMSP")
+}
+
+// readOwnedRecord returns the live record at key if the client owns it.
+// Records written before ownership was tracked can be managed by the admin.
+func readOwnedRecord(ctx contractapi.TransactionContextInterface, key string) (*RecordData, error) {
+	recordData, err := readRecord(ctx, "", key)
+	if err != nil {
+		return nil, err
+	}
+	live, err := isLive(ctx, recordData)
+	if err != nil {
+		return nil, err
+	}
+	if !live {
+		return nil, fmt.Errorf("cannot read world state pair with key %s. Does not exist", key)
+	}
+	err = checkAccessPolicy(ctx, "", AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if recordData.Owner == "" {
+		err = requireAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return recordData, nil
+	}
+	clientID, clientMSPID, err := clientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != recordData.Owner || clientMSPID != recordData.OwnerMSP {
+		return nil, fmt.Errorf("client is not the owner of key %s", key)
+	}
+
+	return recordData, nil
+}
+
+// checkWriteAccess allows the owner and the principals in the ACL to modify a record.
+// Records written before ownership was tracked stay writable by everyone.
+func checkWriteAccess(ctx contractapi.TransactionContextInterface, key string, recordData *RecordData) error {
+	if recordData.Owner == "" {
+		return nil
+	}
+
+	clientID, clientMSPID, err := clientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if clientID == recordData.Owner && clientMSPID == recordData.OwnerMSP {
+		return nil
+	}
+	if acl := recordData.ACL; acl != nil {
+		if containsPrincipal(acl.Identities, clientID) || containsPrincipal(acl.MSPs, clientMSPID) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("client is not authorized to modify key %s", key)
+}
+
+func containsPrincipal(principals []string, principal string) bool {
+	for _, p := range principals {
+		if p == principal {
+			return true
+		}
+	}
+
+	return false
+}
+
+func addPrincipal(principals []string, principal string) []string {
+	if containsPrincipal(principals, principal) {
+		return principals
+	}
+
+	return append(principals, principal)
+}
+
+func removePrincipal(principals []string, principal string) []string {
+	kept := []string{}
+	for _, p := range principals {
+		if p != principal {
+			kept = append(kept, p)
+		}
+	}
+
+	return kept
+}
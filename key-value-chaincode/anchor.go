@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/smallverse/hyperledger-fabric-v2-kubernetes-dev/key-value-chaincode/merkle"
+)
+
+// Define objectType names for prefix
+const anchorPrefix = "anchor"
+
+// Anchor records that a batch of records with the given Merkle root was published
+type Anchor struct {
+	RootHash      string    `json:"rootHash"`
+	Metadata      string    `json:"metadata,omitempty" metadata:",optional"`
+	AnchoredBy    string    `json:"anchoredBy"`
+	AnchoredByMSP string    `json:"anchoredByMSP"`
+	TxID          string    `json:"txId"`
+	AnchoredAt    time.Time `json:"anchoredAt"`
+}
+
+// AnchorBatch anchors the hex Merkle root of a batch of records built with the merkle package.
+// metadata is free text kept with the anchor, e.g. a JSON description of the batch.
+func (sc *KeyValueContract) AnchorBatch(ctx contractapi.TransactionContextInterface, rootHash string, metadata string) error {
+	root, err := hex.DecodeString(rootHash)
+	if err != nil || len(root) != sha256.Size {
+		return fmt.Errorf("root hash %s is not a hex SHA-256 hash", rootHash)
+	}
+	// one spelling per root, so that lookups do not depend on the case of the hex digits
+	rootHash = hex.EncodeToString(root)
+
+	existing, err := readAnchor(ctx, rootHash)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("root hash %s is already anchored", rootHash)
+	}
+
+	anchoredBy, anchoredByMSP, err := clientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	anchoredAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	anchor := Anchor{
+		RootHash:      rootHash,
+		Metadata:      metadata,
+		AnchoredBy:    anchoredBy,
+		AnchoredByMSP: anchoredByMSP,
+		TxID:          ctx.GetStub().GetTxID(),
+		AnchoredAt:    anchoredAt,
+	}
+	anchorKey, err := ctx.GetStub().CreateCompositeKey(anchorPrefix, []string{rootHash})
+	if err != nil {
+		return err
+	}
+	var anchorBytes []byte
+	anchorBytes, _ = json.Marshal(anchor)
+
+	return ctx.GetStub().PutState(anchorKey, anchorBytes)
+}
+
+// ReadAnchor returns the anchor of a Merkle root
+func (sc *KeyValueContract) ReadAnchor(ctx contractapi.TransactionContextInterface, rootHash string) (*Anchor, error) {
+	anchor, err := readAnchor(ctx, rootHash)
+	if err != nil {
+		return nil, err
+	}
+	if anchor == nil {
+		return nil, fmt.Errorf("root hash %s is not anchored", rootHash)
+	}
+
+	return anchor, nil
+}
+
+// VerifyInclusion reports whether proofJSON, a merkle.Proof, shows that recordJSON is part of the
+// batch anchored under rootHash. Only the record and the sibling hashes of the proof are needed,
+// the other records of the batch stay private.
+func (sc *KeyValueContract) VerifyInclusion(ctx contractapi.TransactionContextInterface, rootHash string, recordJSON string, proofJSON string) (bool, error) {
+	anchor, err := sc.ReadAnchor(ctx, rootHash)
+	if err != nil {
+		return false, err
+	}
+
+	var proof merkle.Proof
+	err = json.Unmarshal([]byte(proofJSON), &proof)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal([]byte(proofJSON), &proof) in VerifyInclusion: %v", err)
+		return false, fmt.Errorf("proof is not valid JSON: %v", err)
+	}
+	root, _ := hex.DecodeString(anchor.RootHash)
+
+	return merkle.Verify([]byte(recordJSON), proof, root)
+}
+
+func readAnchor(ctx contractapi.TransactionContextInterface, rootHash string) (*Anchor, error) {
+	root, err := hex.DecodeString(rootHash)
+	if err != nil {
+		return nil, fmt.Errorf("root hash %s is not hex encoded", rootHash)
+	}
+	anchorKey, err := ctx.GetStub().CreateCompositeKey(anchorPrefix, []string{hex.EncodeToString(root)})
+	if err != nil {
+		return nil, err
+	}
+	anchorBytes, err := ctx.GetStub().GetState(anchorKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read anchor %s: %v", rootHash, err)
+	}
+	if anchorBytes == nil {
+		return nil, nil
+	}
+
+	var anchor Anchor
+	err = json.Unmarshal(anchorBytes, &anchor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &anchor, nil
+}
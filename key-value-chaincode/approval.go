@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define objectType names for prefix
+const proposalPrefix = "proposal"
+
+// ChangeProposedEvent is emitted when an update becomes a change proposal
+const ChangeProposedEvent = "ChangeProposed"
+
+// Statuses of a change proposal
+const (
+	ProposalPending   = "pending"
+	ProposalCommitted = "committed"
+	ProposalRejected  = "rejected"
+	// ProposalStale is set when the record changed between the proposal and its last approval
+	ProposalStale = "stale"
+)
+
+// ApprovalPolicy requires Threshold of the ApproverMSPs to approve an update of a namespace
+type ApprovalPolicy struct {
+	ApproverMSPs []string `json:"approverMSPs"`
+	Threshold    int      `json:"threshold"`
+}
+
+// ChangeProposal is an update of a record waiting for approval.
+// The policy is copied from the namespace when the proposal is made.
+type ChangeProposal struct {
+	ID            string         `json:"id"`
+	Namespace     string         `json:"namespace"`
+	Key           string         `json:"key"`
+	BaseVersion   int            `json:"baseVersion"`
+	Record        RecordData     `json:"record"`
+	Policy        ApprovalPolicy `json:"policy"`
+	Approvals     []string       `json:"approvals"`
+	Rejections    []string       `json:"rejections"`
+	Status        string         `json:"status"`
+	ProposedBy    string         `json:"proposedBy"`
+	ProposedByMSP string         `json:"proposedByMSP"`
+	ProposedAt    time.Time      `json:"proposedAt"`
+	ResolvedTxID  string         `json:"resolvedTxId,omitempty" metadata:",optional"`
+}
+
+// ApproveChange approves a pending change proposal for the client's MSP.
+// The change is committed in the same transaction once the threshold is met.
+func (sc *KeyValueContract) ApproveChange(ctx contractapi.TransactionContextInterface, namespace string, proposalID string) (*ChangeProposal, error) {
+	proposal, clientMSPID, err := readVotableProposal(ctx, namespace, proposalID)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal.Approvals = append(proposal.Approvals, clientMSPID)
+	event, err := resolveProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+	err = putProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+	if event != nil {
+		err = emitRecordEvent(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return proposal, nil
+}
+
+// RejectChange rejects a pending change proposal for the client's MSP.
+// The proposal is rejected once the threshold can no longer be met.
+func (sc *KeyValueContract) RejectChange(ctx contractapi.TransactionContextInterface, namespace string, proposalID string) (*ChangeProposal, error) {
+	proposal, clientMSPID, err := readVotableProposal(ctx, namespace, proposalID)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal.Rejections = append(proposal.Rejections, clientMSPID)
+	_, err = resolveProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+	err = putProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+// GetProposal returns a change proposal together with its approvals, rejections and status
+func (sc *KeyValueContract) GetProposal(ctx contractapi.TransactionContextInterface, namespace string, proposalID string) (*ChangeProposal, error) {
+	err := checkAccessPolicy(ctx, namespace, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	proposal, err := readProposal(ctx, namespace, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	if proposal == nil {
+		return nil, fmt.Errorf("change proposal %s in namespace %s does not exist", proposalID, namespace)
+	}
+
+	return proposal, nil
+}
+
+// ListPendingProposals returns the change proposals of a namespace that are still waiting for approval
+func (sc *KeyValueContract) ListPendingProposals(ctx contractapi.TransactionContextInterface, namespace string) ([]ChangeProposal, error) {
+	err := checkAccessPolicy(ctx, namespace, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(proposalPrefix, []string{namespace})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list change proposals of namespace %s: %v", namespace, err)
+	}
+	defer iterator.Close()
+
+	proposals := []ChangeProposal{}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+
+		var proposal ChangeProposal
+		err = json.Unmarshal(result.Value, &proposal)
+		if err != nil {
+			log.Printf("failed to json.Unmarshal value of key %s: %v", result.Key, err)
+			continue
+		}
+		if proposal.Status == ProposalPending {
+			proposals = append(proposals, proposal)
+		}
+	}
+
+	return proposals, nil
+}
+
+// proposeChange stores an update of the record at key as a change proposal.
+// The update is validated now, approvers only decide whether it is applied.
+func proposeChange(ctx contractapi.TransactionContextInterface, settings *NamespaceSettings, key string, value string) error {
+	existing, err := readRecord(ctx, settings.Namespace, key)
+	if err != nil {
+		return err
+	}
+	live, err := isLive(ctx, existing)
+	if err != nil {
+		return err
+	}
+	if !live {
+		return fmt.Errorf("cannot update world state pair with key %s. Does not exist", key)
+	}
+	err = checkWriteAccess(ctx, key, existing)
+	if err != nil {
+		return err
+	}
+	err = checkNotFrozen(ctx, settings.Namespace, key)
+	if err != nil {
+		return err
+	}
+	recordData, err := buildRecord(ctx, settings.Namespace, key, value, existing)
+	if err != nil {
+		return err
+	}
+
+	proposedBy, proposedByMSP, err := clientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	proposedAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	proposal := ChangeProposal{
+		ID:            ctx.GetStub().GetTxID(),
+		Namespace:     settings.Namespace,
+		Key:           key,
+		BaseVersion:   existing.Version,
+		Record:        recordData,
+		Policy:        *settings.Approval,
+		Approvals:     []string{},
+		Rejections:    []string{},
+		Status:        ProposalPending,
+		ProposedBy:    proposedBy,
+		ProposedByMSP: proposedByMSP,
+		ProposedAt:    proposedAt,
+	}
+	// the proposing org approves its own change
+	if containsPrincipal(proposal.Policy.ApproverMSPs, proposedByMSP) {
+		proposal.Approvals = append(proposal.Approvals, proposedByMSP)
+	}
+
+	event, err := resolveProposal(ctx, &proposal)
+	if err != nil {
+		return err
+	}
+	err = putProposal(ctx, &proposal)
+	if err != nil {
+		return err
+	}
+	if event != nil {
+		return emitRecordEvent(ctx, event)
+	}
+
+	var proposalBytes []byte
+	proposalBytes, _ = json.Marshal(proposal)
+	err = ctx.GetStub().SetEvent(ChangeProposedEvent, proposalBytes)
+	if err != nil {
+		log.Printf("failed to %s SetEvent: %v", ChangeProposedEvent, err)
+		return fmt.Errorf("failed to emit %s event: %v", ChangeProposedEvent, err)
+	}
+
+	return nil
+}
+
+// resolveProposal commits a pending proposal that reached its threshold and rejects one that
+// can no longer reach it. It returns the event of the committed change, if any.
+func resolveProposal(ctx contractapi.TransactionContextInterface, proposal *ChangeProposal) (*RecordEvent, error) {
+	policy := proposal.Policy
+	if len(policy.ApproverMSPs)-len(proposal.Rejections) < policy.Threshold {
+		proposal.Status = ProposalRejected
+		proposal.ResolvedTxID = ctx.GetStub().GetTxID()
+		return nil, nil
+	}
+	if len(proposal.Approvals) < policy.Threshold {
+		return nil, nil
+	}
+
+	proposal.ResolvedTxID = ctx.GetStub().GetTxID()
+	existing, err := readRecord(ctx, proposal.Namespace, proposal.Key)
+	if err != nil {
+		return nil, err
+	}
+	live, err := isLive(ctx, existing)
+	if err != nil {
+		return nil, err
+	}
+	if !live || existing.Version != proposal.BaseVersion {
+		proposal.Status = ProposalStale
+		return nil, nil
+	}
+
+	recordData := proposal.Record
+	recordData.TxID = ctx.GetStub().GetTxID()
+	event, err := writeRecord(ctx, proposal.Key, &recordData, existing)
+	if err != nil {
+		return nil, err
+	}
+	proposal.Status = ProposalCommitted
+
+	return event, nil
+}
+
+// readVotableProposal returns a pending proposal the client's MSP may still vote on
+func readVotableProposal(ctx contractapi.TransactionContextInterface, namespace string, proposalID string) (*ChangeProposal, string, error) {
+	proposal, err := readProposal(ctx, namespace, proposalID)
+	if err != nil {
+		return nil, "", err
+	}
+	if proposal == nil {
+		return nil, "", fmt.Errorf("change proposal %s in namespace %s does not exist", proposalID, namespace)
+	}
+	if proposal.Status != ProposalPending {
+		return nil, "", fmt.Errorf("change proposal %s is %s", proposalID, proposal.Status)
+	}
+
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get MSPID: %v", err)
+	}
+	if !containsPrincipal(proposal.Policy.ApproverMSPs, clientMSPID) {
+		return nil, "", fmt.Errorf("MSP %s is not an approver of change proposal %s", clientMSPID, proposalID)
+	}
+	if containsPrincipal(proposal.Approvals, clientMSPID) || containsPrincipal(proposal.Rejections, clientMSPID) {
+		return nil, "", fmt.Errorf("MSP %s has already voted on change proposal %s", clientMSPID, proposalID)
+	}
+
+	return proposal, clientMSPID, nil
+}
+
+func readProposal(ctx contractapi.TransactionContextInterface, namespace string, proposalID string) (*ChangeProposal, error) {
+	proposalKey, err := ctx.GetStub().CreateCompositeKey(proposalPrefix, []string{namespace, proposalID})
+	if err != nil {
+		return nil, err
+	}
+	proposalBytes, err := ctx.GetStub().GetState(proposalKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change proposal %s: %v", proposalID, err)
+	}
+	if proposalBytes == nil {
+		return nil, nil
+	}
+
+	var proposal ChangeProposal
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proposal, nil
+}
+
+func putProposal(ctx contractapi.TransactionContextInterface, proposal *ChangeProposal) error {
+	proposalKey, err := ctx.GetStub().CreateCompositeKey(proposalPrefix, []string{proposal.Namespace, proposal.ID})
+	if err != nil {
+		return err
+	}
+	var proposalBytes []byte
+	proposalBytes, _ = json.Marshal(proposal)
+	err = ctx.GetStub().PutState(proposalKey, proposalBytes)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+
+	return nil
+}
+
+func checkApprovalPolicy(policy *ApprovalPolicy) error {
+	if len(policy.ApproverMSPs) == 0 {
+		return errors.New("an approval policy needs at least one approver MSP")
+	}
+	for i, msp := range policy.ApproverMSPs {
+		if msp == "" || containsPrincipal(policy.ApproverMSPs[:i], msp) {
+			return fmt.Errorf("approver MSPs must be unique and not empty")
+		}
+	}
+	if policy.Threshold < 1 || policy.Threshold > len(policy.ApproverMSPs) {
+		return fmt.Errorf("approval threshold must be between 1 and %d", len(policy.ApproverMSPs))
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Operations accepted by BatchPut
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+)
+
+// maxBatchOps keeps a batch well below the transaction size the orderer accepts
+const maxBatchOps = 500
+
+// BatchOp is one operation of a BatchPut, value is the record for create and update
+type BatchOp struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// BatchResult reports one applied operation of a BatchPut
+type BatchResult struct {
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+}
+
+// BatchPut applies a JSON array of create, update and delete operations in one transaction.
+// Every operation is checked like the single-key transaction, if any of them fails nothing is written
+// and the error lists every failing operation. A key may appear only once per batch, the children a delete
+// cascades to count as keys of that delete.
+func (sc *KeyValueContract) BatchPut(ctx contractapi.TransactionContextInterface, opsJSON string) ([]BatchResult, error) {
+	var ops []BatchOp
+	err := json.Unmarshal([]byte(opsJSON), &ops)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal([]byte(opsJSON), &ops) in BatchPut: %v", err)
+		return nil, err
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("batch must contain at least one operation")
+	}
+	if len(ops) > maxBatchOps {
+		return nil, fmt.Errorf("batch of %d operations exceeds the limit of %d", len(ops), maxBatchOps)
+	}
+
+	// the world state does not show writes of the running transaction, so every key is touched once
+	seen := make(map[string]int)
+	for i, op := range ops {
+		if first, ok := seen[op.Key]; ok {
+			return nil, fmt.Errorf("op %d repeats key %s of op %d", i, op.Key, first)
+		}
+		seen[op.Key] = i
+	}
+
+	results := []BatchResult{}
+	events := []RecordEvent{}
+	var failures []string
+	for i, op := range ops {
+		event, err := applyBatchOp(ctx, op)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("op %d (%s %s): %v", i, op.Op, op.Key, err))
+			continue
+		}
+		// the cascade and the other operation would both write the key, and the later write would win
+		for _, child := range event.Cascaded {
+			if other, ok := seen[child]; ok {
+				failures = append(failures, fmt.Sprintf("op %d (%s %s): deletes key %s of op %d", i, op.Op, op.Key, child, other))
+			}
+		}
+		results = append(results, BatchResult{Index: i, Op: op.Op, Key: op.Key})
+		events = append(events, *event)
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("batch rejected, %d of %d operations failed: %s", len(failures), len(ops), strings.Join(failures, "; "))
+	}
+
+	// a transaction carries a single chaincode event, so the events of all operations go out together
+	err = emitBatchEvent(ctx, events)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func applyBatchOp(ctx contractapi.TransactionContextInterface, op BatchOp) (*RecordEvent, error) {
+	switch op.Op {
+	case BatchOpCreate:
+		return createData(ctx, "", op.Key, string(op.Value))
+	case BatchOpUpdate:
+		return updateData(ctx, "", op.Key, string(op.Value))
+	case BatchOpDelete:
+		return deleteData(ctx, "", op.Key)
+	default:
+		return nil, fmt.Errorf("unknown operation, must be %s, %s or %s", BatchOpCreate, BatchOpUpdate, BatchOpDelete)
+	}
+}
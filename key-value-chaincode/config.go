@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define key names for settings
+const configKey = "config"
+
+// DefaultAdminMSP administers the chaincode until SetConfig names another MSP
+const DefaultAdminMSP = "Org1MSP"
+
+// ChaincodeConfig holds the limits of the chaincode, the erc721 chaincode keeps a separate config of the same format
+// since it is deployed on its own channel.
+// A limit of 0 is no limit, MaxJSONDepth counts nested objects and arrays so a flat object has depth 1.
+// A key must fully match one of the KeyPatterns, Go regular expressions, empty KeyPatterns allow every key.
+type ChaincodeConfig struct {
+	AdminMSP      string   `json:"adminMSP"`
+	MaxAttributes int      `json:"maxAttributes"`
+	MaxValueBytes int      `json:"maxValueBytes"`
+	MaxJSONDepth  int      `json:"maxJSONDepth"`
+	KeyPatterns   []string `json:"keyPatterns"`
+	TxID          string   `json:"txId"`
+}
+
+// defaultConfig applies until SetConfig is called, it keeps the former limit of 100 TokenData attributes
+func defaultConfig() *ChaincodeConfig {
+	return &ChaincodeConfig{
+		AdminMSP:      DefaultAdminMSP,
+		MaxAttributes: 100,
+		KeyPatterns:   []string{},
+	}
+}
+
+// GetConfig returns the current config
+func (sc *KeyValueContract) GetConfig(ctx contractapi.TransactionContextInterface) (*ChaincodeConfig, error) {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return readConfig(ctx)
+}
+
+// SetConfig changes the config, configJSON holds the fields to change and the others keep their value.
+// The limits apply to records written afterwards. Setting adminMSP hands the chaincode over to another MSP.
+func (sc *KeyValueContract) SetConfig(ctx contractapi.TransactionContextInterface, configJSON string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal([]byte(configJSON), config)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal([]byte(configJSON), config) in SetConfig: %v", err)
+		return fmt.Errorf("config is not valid JSON: %v", err)
+	}
+	if config.AdminMSP == "" {
+		return errors.New("adminMSP must not be empty")
+	}
+	if config.MaxAttributes < 0 || config.MaxValueBytes < 0 || config.MaxJSONDepth < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if config.KeyPatterns == nil {
+		config.KeyPatterns = []string{}
+	}
+	for _, pattern := range config.KeyPatterns {
+		_, err = compileKeyPattern(pattern)
+		if err != nil {
+			return err
+		}
+	}
+	config.TxID = ctx.GetStub().GetTxID()
+
+	configStateKey, err := ctx.GetStub().CreateCompositeKey(settingPrefix, []string{configKey})
+	if err != nil {
+		return err
+	}
+	var configBytes []byte
+	configBytes, _ = json.Marshal(config)
+
+	return ctx.GetStub().PutState(configStateKey, configBytes)
+}
+
+func readConfig(ctx contractapi.TransactionContextInterface) (*ChaincodeConfig, error) {
+	configStateKey, err := ctx.GetStub().CreateCompositeKey(settingPrefix, []string{configKey})
+	if err != nil {
+		return nil, err
+	}
+	configBytes, err := ctx.GetStub().GetState(configStateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	if configBytes == nil {
+		return defaultConfig(), nil
+	}
+
+	var config ChaincodeConfig
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// checkKeyPattern fails unless key matches one of the key patterns of the config
+func checkKeyPattern(config *ChaincodeConfig, key string) error {
+	if len(config.KeyPatterns) == 0 {
+		return nil
+	}
+	for _, pattern := range config.KeyPatterns {
+		keyPattern, err := compileKeyPattern(pattern)
+		if err != nil {
+			return err
+		}
+		if keyPattern.MatchString(key) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("key %s does not match any of the key patterns %s", key, strings.Join(config.KeyPatterns, ", "))
+}
+
+// checkJSONLimits checks the nesting depth of value and the size of its string values
+func checkJSONLimits(config *ChaincodeConfig, value string) error {
+	if config.MaxJSONDepth == 0 && config.MaxValueBytes == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var decoded interface{}
+	err := decoder.Decode(&decoded)
+	if err != nil {
+		return err
+	}
+
+	return checkJSONValue(config, decoded, 0)
+}
+
+// checkJSONValue checks a value nested depth levels deep, visiting object keys in order
+// so that every peer reports the same violation
+func checkJSONValue(config *ChaincodeConfig, value interface{}, depth int) error {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if config.MaxJSONDepth > 0 && depth >= config.MaxJSONDepth {
+			return fmt.Errorf("value is nested deeper than maxJSONDepth %d", config.MaxJSONDepth)
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			err := checkJSONValue(config, value[key], depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if config.MaxJSONDepth > 0 && depth >= config.MaxJSONDepth {
+			return fmt.Errorf("value is nested deeper than maxJSONDepth %d", config.MaxJSONDepth)
+		}
+		for _, element := range value {
+			err := checkJSONValue(config, element, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	case string:
+		if config.MaxValueBytes > 0 && len(value) > config.MaxValueBytes {
+			return fmt.Errorf("value has a string of %d bytes, more than maxValueBytes %d", len(value), config.MaxValueBytes)
+		}
+	}
+
+	return nil
+}
+
+func compileKeyPattern(pattern string) (*regexp.Regexp, error) {
+	keyPattern, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("key pattern %s is not a valid regular expression: %v", pattern, err)
+	}
+
+	return keyPattern, nil
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define objectType names for prefix
+const (
+	uploadPrefix        = "upload"
+	uploadChunkPrefix   = "uploadChunk"
+	contentUploadPrefix = "contentUpload"
+)
+
+// ChunkedContentScheme prefixes the URI of content uploaded to the ledger in chunks, it is followed by the upload id
+const ChunkedContentScheme = "chunks://"
+
+// maxUploadSize, maxChunkSize and maxChunkCount bound a chunked upload. CommitUpload reads and hashes every chunk
+// in one transaction, so the whole upload must fit within the execution timeout of the peer, and a batch of
+// chunks returned by the range query must fit within its 100 MB gRPC message limit.
+const (
+	maxUploadSize = 32 << 20
+	maxChunkSize  = 256 << 10
+	maxChunkCount = 1024
+)
+
+// ContentRef describes the payload of a content-addressed record, which is not stored in the record itself.
+// URI points to the payload off-chain, or to the chunks of an upload for content uploaded with BeginUpload.
+type ContentRef struct {
+	Digest string `json:"digest"`
+	Size   int    `json:"size"`
+	URI    string `json:"uri"`
+	Chunks int    `json:"chunks,omitempty" metadata:",optional"`
+}
+
+// Upload is a chunked upload in progress, its id is the tx id of BeginUpload
+type Upload struct {
+	ID          string    `json:"id"`
+	Key         string    `json:"key"`
+	Record      string    `json:"record"`
+	Digest      string    `json:"digest"`
+	Size        int       `json:"size"`
+	Uploader    string    `json:"uploader"`
+	UploaderMSP string    `json:"uploaderMSP"`
+	StartedAt   time.Time `json:"startedAt"`
+}
+
+// BeginUpload starts a chunked upload of content with the hex SHA-256 digest and size in bytes for the
+// record at key, value is the record without its content. The size is at most 32 MiB, in chunks of at most 256 KiB.
+// The record is written by CommitUpload only, until then it cannot be read. It returns the upload id, which is the tx id.
+func (sc *KeyValueContract) BeginUpload(ctx contractapi.TransactionContextInterface, key string, value string, digest string, size int) (string, error) {
+	existing, err := checkUploadTarget(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	digest, err = normalizeDigest(digest)
+	if err != nil {
+		return "", err
+	}
+	if size < 0 || size > maxUploadSize {
+		return "", fmt.Errorf("size must be between 0 and %d", maxUploadSize)
+	}
+	// fail early, CommitUpload validates the record again
+	recordData, err := buildRecord(ctx, "", key, value, existing)
+	if err != nil {
+		return "", err
+	}
+	if recordData.Content != nil {
+		return "", errors.New("the record of an upload must not have content, it is set by CommitUpload")
+	}
+
+	uploader, uploaderMSP, err := clientIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	startedAt, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	upload := Upload{
+		ID:          ctx.GetStub().GetTxID(),
+		Key:         key,
+		Record:      value,
+		Digest:      digest,
+		Size:        size,
+		Uploader:    uploader,
+		UploaderMSP: uploaderMSP,
+		StartedAt:   startedAt,
+	}
+	uploadKey, err := ctx.GetStub().CreateCompositeKey(uploadPrefix, []string{upload.ID})
+	if err != nil {
+		return "", err
+	}
+	var uploadBytes []byte
+	uploadBytes, _ = json.Marshal(upload)
+	err = ctx.GetStub().PutState(uploadKey, uploadBytes)
+	if err != nil {
+		return "", errors.New("unable to interact with world state")
+	}
+
+	return upload.ID, nil
+}
+
+// PutChunk stores or replaces chunk index of an upload, chunkBase64 is the base64 encoded chunk.
+// Chunks are numbered from 0 and may be put in any order and in separate transactions.
+func (sc *KeyValueContract) PutChunk(ctx contractapi.TransactionContextInterface, uploadID string, index int, chunkBase64 string) error {
+	_, err := readOwnUpload(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= maxChunkCount {
+		return fmt.Errorf("chunk index must be between 0 and %d", maxChunkCount-1)
+	}
+	chunk, err := base64.StdEncoding.DecodeString(chunkBase64)
+	if err != nil {
+		return fmt.Errorf("chunk %d is not base64 encoded: %v", index, err)
+	}
+	if len(chunk) == 0 || len(chunk) > maxChunkSize {
+		return fmt.Errorf("chunk %d must have between 1 and %d bytes", index, maxChunkSize)
+	}
+
+	chunkKey, err := uploadChunkKey(ctx, uploadID, index)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(chunkKey, chunk)
+}
+
+// CommitUpload reassembles the chunks of an upload and writes its record once they match the
+// digest and size given to BeginUpload. The chunks stay on the ledger as the content of the record.
+func (sc *KeyValueContract) CommitUpload(ctx contractapi.TransactionContextInterface, uploadID string) (string, error) {
+	upload, err := readOwnUpload(ctx, uploadID)
+	if err != nil {
+		return "", err
+	}
+	existing, err := checkUploadTarget(ctx, upload.Key)
+	if err != nil {
+		return upload.Key, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(uploadChunkPrefix, []string{uploadID})
+	if err != nil {
+		return upload.Key, fmt.Errorf("unable to read chunks of upload %s: %v", uploadID, err)
+	}
+	defer iterator.Close()
+
+	digest := sha256.New()
+	size := 0
+	chunks := 0
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return upload.Key, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+		// chunk keys sort by index, so a gap shows up as an unexpected key
+		expectedKey, err := uploadChunkKey(ctx, uploadID, chunks)
+		if err != nil {
+			return upload.Key, err
+		}
+		if result.Key != expectedKey {
+			return upload.Key, fmt.Errorf("chunk %d of upload %s is missing", chunks, uploadID)
+		}
+		digest.Write(result.Value)
+		size += len(result.Value)
+		chunks++
+		// stop reading chunks put beyond the size given to BeginUpload
+		if size > upload.Size {
+			return upload.Key, fmt.Errorf("upload %s has more than the %d bytes expected", uploadID, upload.Size)
+		}
+	}
+	if size != upload.Size {
+		return upload.Key, fmt.Errorf("upload %s has %d bytes in %d chunks, expected %d", uploadID, size, chunks, upload.Size)
+	}
+	if hex.EncodeToString(digest.Sum(nil)) != upload.Digest {
+		return upload.Key, fmt.Errorf("content of upload %s does not match digest %s", uploadID, upload.Digest)
+	}
+
+	recordData, err := buildRecord(ctx, "", upload.Key, upload.Record, existing)
+	if err != nil {
+		return upload.Key, err
+	}
+	recordData.Content = &ContentRef{
+		Digest: upload.Digest,
+		Size:   upload.Size,
+		URI:    ChunkedContentScheme + uploadID,
+		Chunks: chunks,
+	}
+	event, err := writeRecord(ctx, upload.Key, &recordData, existing)
+	if err != nil {
+		return upload.Key, err
+	}
+	err = deleteUpload(ctx, uploadID)
+	if err != nil {
+		return upload.Key, err
+	}
+	// the chunks are kept for the history of the record until it is deleted, see deleteRecordChunks
+	contentUploadKey, err := ctx.GetStub().CreateCompositeKey(contentUploadPrefix, []string{upload.Key, uploadID})
+	if err != nil {
+		return upload.Key, err
+	}
+	err = ctx.GetStub().PutState(contentUploadKey, []byte{0x00})
+	if err != nil {
+		return upload.Key, errors.New("unable to interact with world state")
+	}
+
+	return upload.Key, emitRecordEvent(ctx, event)
+}
+
+// AbortUpload discards an upload together with its chunks
+func (sc *KeyValueContract) AbortUpload(ctx contractapi.TransactionContextInterface, uploadID string) error {
+	_, err := readOwnUpload(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	err = deleteUploadChunks(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	return deleteUpload(ctx, uploadID)
+}
+
+// ReadChunk returns chunk index of the content uploaded for the record at key, base64 encoded.
+// The chunks of earlier versions stay readable for the history of the record until the record is deleted.
+func (sc *KeyValueContract) ReadChunk(ctx contractapi.TransactionContextInterface, key string, index int) (string, error) {
+	err := checkAccessPolicy(ctx, "", AccessRead)
+	if err != nil {
+		return "", err
+	}
+	recordData, err := readRecord(ctx, "", key)
+	if err != nil {
+		return "", err
+	}
+	live, err := isLive(ctx, recordData)
+	if err != nil {
+		return "", err
+	}
+	if !live {
+		return "", fmt.Errorf("cannot read world state pair with key %s. Does not exist", key)
+	}
+	if recordData.Content == nil || !strings.HasPrefix(recordData.Content.URI, ChunkedContentScheme) {
+		return "", fmt.Errorf("world state pair with key %s has no uploaded content", key)
+	}
+	if index < 0 || index >= recordData.Content.Chunks {
+		return "", fmt.Errorf("chunk index must be between 0 and %d", recordData.Content.Chunks-1)
+	}
+
+	uploadID := strings.TrimPrefix(recordData.Content.URI, ChunkedContentScheme)
+	chunkKey, err := uploadChunkKey(ctx, uploadID, index)
+	if err != nil {
+		return "", err
+	}
+	chunk, err := ctx.GetStub().GetState(chunkKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read chunk %d of key %s: %v", index, key, err)
+	}
+	if chunk == nil {
+		return "", fmt.Errorf("chunk %d of key %s does not exist", index, key)
+	}
+
+	return base64.StdEncoding.EncodeToString(chunk), nil
+}
+
+// validateContent checks the content reference of a record. Chunked content is set by CommitUpload only,
+// a client may keep the chunked content of the previous record but not point to other chunks.
+func validateContent(recordData *RecordData, previous *RecordData) error {
+	content := recordData.Content
+	if content == nil {
+		return nil
+	}
+	digest, err := normalizeDigest(content.Digest)
+	if err != nil {
+		return err
+	}
+	content.Digest = digest
+	if content.Size < 0 {
+		return errors.New("content size must not be negative")
+	}
+	if content.URI == "" {
+		return errors.New("content uri must not be empty")
+	}
+
+	if !strings.HasPrefix(content.URI, ChunkedContentScheme) {
+		if content.Chunks != 0 {
+			return errors.New("only uploaded content has chunks")
+		}
+		return nil
+	}
+	if previous == nil || previous.Content == nil || *previous.Content != *content {
+		return fmt.Errorf("content with a %s uri can only be uploaded with BeginUpload", ChunkedContentScheme)
+	}
+
+	return nil
+}
+
+// checkUploadTarget returns the current record at key if the client may upload content for it
+func checkUploadTarget(ctx contractapi.TransactionContextInterface, key string) (*RecordData, error) {
+	existing, err := readRecord(ctx, "", key)
+	if err != nil {
+		return nil, err
+	}
+	live, err := isLive(ctx, existing)
+	if err != nil {
+		return nil, err
+	}
+	if live {
+		err = checkWriteAccess(ctx, key, existing)
+	} else {
+		err = checkCreatable(ctx, key, existing)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+// readOwnUpload returns an upload started by the client
+func readOwnUpload(ctx contractapi.TransactionContextInterface, uploadID string) (*Upload, error) {
+	uploadKey, err := ctx.GetStub().CreateCompositeKey(uploadPrefix, []string{uploadID})
+	if err != nil {
+		return nil, err
+	}
+	uploadBytes, err := ctx.GetStub().GetState(uploadKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload %s: %v", uploadID, err)
+	}
+	if uploadBytes == nil {
+		return nil, fmt.Errorf("upload %s does not exist", uploadID)
+	}
+
+	var upload Upload
+	err = json.Unmarshal(uploadBytes, &upload)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal value of upload %s: %v", uploadID, err)
+		return nil, err
+	}
+
+	clientID, clientMSPID, err := clientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != upload.Uploader || clientMSPID != upload.UploaderMSP {
+		return nil, fmt.Errorf("upload %s was started by another client", uploadID)
+	}
+
+	return &upload, nil
+}
+
+func deleteUpload(ctx contractapi.TransactionContextInterface, uploadID string) error {
+	uploadKey, err := ctx.GetStub().CreateCompositeKey(uploadPrefix, []string{uploadID})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(uploadKey)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+
+	return nil
+}
+
+// deleteRecordChunks deletes the chunks of every upload committed for the record at key, recordData is
+// the record being deleted. A soft-deleted record keeps its chunks.
+func deleteRecordChunks(ctx contractapi.TransactionContextInterface, key string, recordData *RecordData) error {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(contentUploadPrefix, []string{key})
+	if err != nil {
+		return fmt.Errorf("unable to read uploads of key %s: %v", key, err)
+	}
+	defer iterator.Close()
+
+	uploadIDs := []string{}
+	tracked := map[string]bool{}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return fmt.Errorf("unable to interact with world state: %v", err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(result.Key)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(result.Key)
+		if err != nil {
+			return errors.New("unable to interact with world state")
+		}
+		uploadIDs = append(uploadIDs, attributes[1])
+		tracked[attributes[1]] = true
+	}
+	// records uploaded before their uploads were tracked only know their current upload
+	if recordData.Content != nil && strings.HasPrefix(recordData.Content.URI, ChunkedContentScheme) {
+		uploadID := strings.TrimPrefix(recordData.Content.URI, ChunkedContentScheme)
+		if !tracked[uploadID] {
+			uploadIDs = append(uploadIDs, uploadID)
+		}
+	}
+
+	for _, uploadID := range uploadIDs {
+		err = deleteUploadChunks(ctx, uploadID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteUploadChunks(ctx contractapi.TransactionContextInterface, uploadID string) error {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(uploadChunkPrefix, []string{uploadID})
+	if err != nil {
+		return fmt.Errorf("unable to read chunks of upload %s: %v", uploadID, err)
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return fmt.Errorf("unable to interact with world state: %v", err)
+		}
+		err = ctx.GetStub().DelState(result.Key)
+		if err != nil {
+			return errors.New("unable to interact with world state")
+		}
+	}
+
+	return nil
+}
+
+// uploadChunkKey pads the index so that the chunks of an upload sort in order
+func uploadChunkKey(ctx contractapi.TransactionContextInterface, uploadID string, index int) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(uploadChunkPrefix, []string{uploadID, fmt.Sprintf("%05d", index)})
+}
+
+func normalizeDigest(digest string) (string, error) {
+	digestBytes, err := hex.DecodeString(digest)
+	if err != nil || len(digestBytes) != sha256.Size {
+		return "", fmt.Errorf("digest %s is not a hex SHA-256 hash", digest)
+	}
+
+	return hex.EncodeToString(digestBytes), nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define key names of the transient map
+const (
+	transientEncryptionKey    = "encryptionKey"
+	transientNewEncryptionKey = "newEncryptionKey"
+)
+
+// EncryptionAlgorithm is the only cipher used for encrypted records
+const EncryptionAlgorithm = "AES-256-GCM"
+
+// encryptionKeyLen is the key size of AES-256
+const encryptionKeyLen = 32
+
+// EncryptedPayload holds a record encrypted with a key known only to its clients.
+// KeyFingerprint is the hex SHA-256 of the key, it tells a wrong key apart from a damaged ciphertext.
+type EncryptedPayload struct {
+	Algorithm      string `json:"algorithm"`
+	Nonce          string `json:"nonce"`
+	Ciphertext     string `json:"ciphertext"`
+	KeyFingerprint string `json:"keyFingerprint"`
+}
+
+// CreateEncrypted adds the record passed in the transient map under "record" encrypted with the
+// AES-256 key passed under "encryptionKey". The world state keeps only the ciphertext together with
+// the key, version, ownership and expiry of the record. The endorsing peers see the plaintext while
+// they execute the transaction but neither the record nor the key is written to the ledger.
+func (sc *KeyValueContract) CreateEncrypted(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	existing, err := readRecord(ctx, "", key)
+	if err != nil {
+		return key, err
+	}
+	err = checkCreatable(ctx, key, existing)
+	if err != nil {
+		return key, err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return key, fmt.Errorf("failed to get transient map: %v", err)
+	}
+	value, ok := transientMap[transientRecordKey]
+	if !ok {
+		return key, fmt.Errorf("the record must be passed in the transient map under %s", transientRecordKey)
+	}
+	encryptionKey, err := transientEncryptionKeyValue(transientMap, transientEncryptionKey)
+	if err != nil {
+		return key, err
+	}
+
+	recordData, err := buildRecord(ctx, "", key, string(value), existing)
+	if err != nil {
+		return key, err
+	}
+	encrypted, err := encryptRecord(ctx, key, &recordData, encryptionKey)
+	if err != nil {
+		return key, err
+	}
+	// the stored record only keeps what access checks, versioning and expiry need
+	envelope := RecordData{
+		Key:       recordData.Key,
+		Version:   recordData.Version,
+		TxID:      recordData.TxID,
+		Owner:     recordData.Owner,
+		OwnerMSP:  recordData.OwnerMSP,
+		ExpiresAt: recordData.ExpiresAt,
+		Encrypted: encrypted,
+	}
+
+	event, err := writeRecord(ctx, key, &envelope, existing)
+	if err != nil {
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+// ReadDecrypted returns the record at key decrypted with the key passed in the transient map under "encryptionKey"
+func (sc *KeyValueContract) ReadDecrypted(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	err := checkAccessPolicy(ctx, "", AccessRead)
+	if err != nil {
+		return "", err
+	}
+	envelope, err := readEncryptedRecord(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to get transient map: %v", err)
+	}
+	encryptionKey, err := transientEncryptionKeyValue(transientMap, transientEncryptionKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := decryptRecord(key, envelope.Encrypted, encryptionKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// ReEncrypt rotates the key of the encrypted record at key. The current key is passed in the
+// transient map under "encryptionKey" and the new one under "newEncryptionKey".
+func (sc *KeyValueContract) ReEncrypt(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	envelope, err := readEncryptedRecord(ctx, key)
+	if err != nil {
+		return key, err
+	}
+	err = checkAccessPolicy(ctx, "", AccessWrite)
+	if err != nil {
+		return key, err
+	}
+	err = checkWriteAccess(ctx, key, envelope)
+	if err != nil {
+		return key, err
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return key, fmt.Errorf("failed to get transient map: %v", err)
+	}
+	encryptionKey, err := transientEncryptionKeyValue(transientMap, transientEncryptionKey)
+	if err != nil {
+		return key, err
+	}
+	newEncryptionKey, err := transientEncryptionKeyValue(transientMap, transientNewEncryptionKey)
+	if err != nil {
+		return key, err
+	}
+
+	plaintext, err := decryptRecord(key, envelope.Encrypted, encryptionKey)
+	if err != nil {
+		return key, err
+	}
+	var recordData RecordData
+	err = json.Unmarshal(plaintext, &recordData)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal decrypted value of key %s: %v", key, err)
+		return key, err
+	}
+	// the encrypted copy follows the version and tx id that storeRecord gives the envelope
+	recordData.Version = envelope.Version + 1
+	recordData.TxID = ctx.GetStub().GetTxID()
+	recordData.Owner = envelope.Owner
+	recordData.OwnerMSP = envelope.OwnerMSP
+	recordData.ACL = envelope.ACL
+	envelope.Encrypted, err = encryptRecord(ctx, key, &recordData, newEncryptionKey)
+	if err != nil {
+		return key, err
+	}
+
+	return key, storeRecordChange(ctx, key, envelope, "encrypted")
+}
+
+func readEncryptedRecord(ctx contractapi.TransactionContextInterface, key string) (*RecordData, error) {
+	envelope, err := readRecord(ctx, "", key)
+	if err != nil {
+		return nil, err
+	}
+	live, err := isLive(ctx, envelope)
+	if err != nil {
+		return nil, err
+	}
+	if !live {
+		return nil, fmt.Errorf("cannot read world state pair with key %s. Does not exist", key)
+	}
+	if envelope.Encrypted == nil {
+		return nil, fmt.Errorf("world state pair with key %s is not encrypted", key)
+	}
+
+	return envelope, nil
+}
+
+// encryptRecord seals a record for the world state key it is stored at.
+// Endorsing peers must agree on the ciphertext, so the nonce is derived from the tx id and key
+// instead of drawn at random; a tx id is never reused, so neither is a nonce.
+func encryptRecord(ctx contractapi.TransactionContextInterface, key string, recordData *RecordData, encryptionKey []byte) (*EncryptedPayload, error) {
+	aead, err := newRecordCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceHash := sha256.New()
+	nonceHash.Write([]byte(ctx.GetStub().GetTxID()))
+	nonceHash.Write([]byte{0})
+	nonceHash.Write([]byte(key))
+	nonce := nonceHash.Sum(nil)[:aead.NonceSize()]
+
+	var recordDataBytes []byte
+	recordDataBytes, _ = json.Marshal(recordData)
+	ciphertext := aead.Seal(nil, nonce, recordDataBytes, []byte(key))
+
+	return &EncryptedPayload{
+		Algorithm:      EncryptionAlgorithm,
+		Nonce:          base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext:     base64.StdEncoding.EncodeToString(ciphertext),
+		KeyFingerprint: keyFingerprint(encryptionKey),
+	}, nil
+}
+
+func decryptRecord(key string, encrypted *EncryptedPayload, encryptionKey []byte) ([]byte, error) {
+	if encrypted.Algorithm != EncryptionAlgorithm {
+		return nil, fmt.Errorf("record %s is encrypted with unsupported algorithm %s", key, encrypted.Algorithm)
+	}
+	if keyFingerprint(encryptionKey) != encrypted.KeyFingerprint {
+		return nil, fmt.Errorf("encryption key does not match the key record %s was encrypted with", key)
+	}
+
+	aead, err := newRecordCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(encrypted.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("record %s has a malformed nonce", key)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("record %s has a malformed ciphertext", key)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt record %s: %v", key, err)
+	}
+
+	return plaintext, nil
+}
+
+func newRecordCipher(encryptionKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func keyFingerprint(encryptionKey []byte) string {
+	fingerprint := sha256.Sum256(encryptionKey)
+
+	return hex.EncodeToString(fingerprint[:])
+}
+
+func transientEncryptionKeyValue(transientMap map[string][]byte, name string) ([]byte, error) {
+	encryptionKey, ok := transientMap[name]
+	if !ok || len(encryptionKey) != encryptionKeyLen {
+		return nil, fmt.Errorf("a %d byte AES-256 key must be passed in the transient map under %s", encryptionKeyLen, name)
+	}
+
+	return encryptionKey, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define names of the chaincode events
+const (
+	RecordCreatedEvent = "RecordCreated"
+	RecordUpdatedEvent = "RecordUpdated"
+	RecordDeletedEvent = "RecordDeleted"
+	// RecordBatchEvent carries the events of every operation of a BatchPut
+	RecordBatchEvent = "RecordBatch"
+)
+
+// transientEventPayloadKey set to "true" in the transient map includes the full record in the event
+const transientEventPayloadKey = "eventPayload"
+
+// RecordEvent is the payload of the RecordCreated, RecordUpdated and RecordDeleted events.
+// Version is the new version of the record, or the last version for a hard delete.
+// Cascaded lists the linked records deleted together with the record.
+type RecordEvent struct {
+	Event         string      `json:"event"`
+	Namespace     string      `json:"namespace,omitempty" metadata:",optional"`
+	Key           string      `json:"key"`
+	Version       int         `json:"version"`
+	ChangedFields []string    `json:"changedFields"`
+	Actor         string      `json:"actor"`
+	ActorMSP      string      `json:"actorMSP"`
+	Record        *RecordData `json:"record,omitempty" metadata:",optional"`
+	Cascaded      []string    `json:"cascaded,omitempty" metadata:",optional"`
+}
+
+// newRecordEvent describes a change to the record at key made by the client
+func newRecordEvent(ctx contractapi.TransactionContextInterface, name string, key string, recordData *RecordData, changedFields []string) (*RecordEvent, error) {
+	actor, actorMSP, err := clientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recordEvent := RecordEvent{
+		Event:         name,
+		Namespace:     recordData.Namespace,
+		Key:           key,
+		Version:       recordData.Version,
+		ChangedFields: changedFields,
+		Actor:         actor,
+		ActorMSP:      actorMSP,
+	}
+	withPayload, err := eventPayloadRequested(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if withPayload {
+		recordEvent.Record = recordData
+	}
+
+	return &recordEvent, nil
+}
+
+// emitRecordEvent sets event as the chaincode event of the transaction
+func emitRecordEvent(ctx contractapi.TransactionContextInterface, event *RecordEvent) error {
+	var eventBytes []byte
+	eventBytes, _ = json.Marshal(event)
+	err := ctx.GetStub().SetEvent(event.Event, eventBytes)
+	if err != nil {
+		log.Printf("failed to %s SetEvent: %v", event.Event, err)
+		return fmt.Errorf("failed to emit %s event: %v", event.Event, err)
+	}
+
+	return nil
+}
+
+// emitBatchEvent sets the events of several changes as one RecordBatch event
+func emitBatchEvent(ctx contractapi.TransactionContextInterface, events []RecordEvent) error {
+	var eventsBytes []byte
+	eventsBytes, _ = json.Marshal(events)
+	err := ctx.GetStub().SetEvent(RecordBatchEvent, eventsBytes)
+	if err != nil {
+		log.Printf("failed to %s SetEvent: %v", RecordBatchEvent, err)
+		return fmt.Errorf("failed to emit %s event: %v", RecordBatchEvent, err)
+	}
+
+	return nil
+}
+
+// storeRecordChange stores a record modified in place and emits a RecordUpdated event for the given fields
+func storeRecordChange(ctx contractapi.TransactionContextInterface, key string, recordData *RecordData, changedFields ...string) error {
+	err := storeRecord(ctx, key, recordData)
+	if err != nil {
+		return err
+	}
+
+	event, err := newRecordEvent(ctx, RecordUpdatedEvent, key, recordData, changedFields)
+	if err != nil {
+		return err
+	}
+
+	return emitRecordEvent(ctx, event)
+}
+
+func eventPayloadRequested(ctx contractapi.TransactionContextInterface) (bool, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return false, fmt.Errorf("failed to get transient map: %v", err)
+	}
+
+	return string(transientMap[transientEventPayloadKey]) == "true", nil
+}
+
+// changedFields lists the top-level JSON fields that differ between two versions of a record,
+// every field of current is listed when previous is nil. version and txId always change and are left out.
+func changedFields(previous *RecordData, current *RecordData) []string {
+	previousFields := map[string]interface{}{}
+	if previous != nil {
+		previousFields = recordFields(previous)
+	}
+	currentFields := recordFields(current)
+
+	changed := []string{}
+	for name, value := range currentFields {
+		if previousValue, ok := previousFields[name]; !ok || !reflect.DeepEqual(previousValue, value) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previousFields {
+		if _, ok := currentFields[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	return changed
+}
+
+func recordFields(recordData *RecordData) map[string]interface{} {
+	var recordDataBytes []byte
+	recordDataBytes, _ = json.Marshal(recordData)
+	fields := map[string]interface{}{}
+	_ = json.Unmarshal(recordDataBytes, &fields)
+	delete(fields, "version")
+	delete(fields, "txId")
+
+	return fields
+}
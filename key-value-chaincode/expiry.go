@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define objectType names for prefix
+const expiryPrefix = "expiry"
+
+// expiryTimeFormat has a fixed width so that expiry index keys sort by time
+const expiryTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// maxPurgePageSize bounds the records deleted by one PurgeExpired transaction
+const maxPurgePageSize = 500
+
+// PurgeExpired deletes up to pageSize records whose expiresAt is not after the transaction timestamp
+// and returns how many were deleted. Call it again while it returns pageSize to purge the rest.
+// Children linked with a cascading type are deleted with their record, a record with live children
+// linked with a restricting type is kept until they are deleted.
+func (sc *KeyValueContract) PurgeExpired(ctx contractapi.TransactionContextInterface, pageSize int) (int, error) {
+	if pageSize <= 0 || pageSize > maxPurgePageSize {
+		return 0, fmt.Errorf("pageSize must be between 1 and %d", maxPurgePageSize)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(expiryPrefix, []string{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to read expiry index: %v", err)
+	}
+	defer iterator.Close()
+
+	events := []RecordEvent{}
+	purged := map[string]bool{}
+	for iterator.HasNext() && len(events) < pageSize {
+		result, err := iterator.Next()
+		if err != nil {
+			return 0, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(result.Key)
+		if err != nil {
+			return 0, err
+		}
+		expiresAt, err := time.Parse(expiryTimeFormat, attributes[0])
+		if err != nil {
+			return 0, fmt.Errorf("malformed expiry index entry %s: %v", result.Key, err)
+		}
+		// the index is sorted by time, everything after this entry expires later
+		if expiresAt.After(now) {
+			break
+		}
+
+		namespace, key := attributes[1], attributes[2]
+		// a frozen record stays until it is unfrozen, its index entry is kept for a later purge
+		frozen, err := readFreeze(ctx, namespace, key)
+		if err != nil {
+			return 0, err
+		}
+		if frozen != nil {
+			continue
+		}
+		// a child purged with its parent in this transaction
+		if namespace == "" && purged[key] {
+			continue
+		}
+		recordData, err := readRecord(ctx, namespace, key)
+		if err != nil {
+			return 0, err
+		}
+		stale := recordData == nil || recordData.ExpiresAt == "" || recordData.Tombstone != nil
+		if !stale {
+			recordExpiresAt, err := parseExpiresAt(recordData.ExpiresAt)
+			stale = err != nil || !recordExpiresAt.Equal(expiresAt)
+		}
+		if stale {
+			err = ctx.GetStub().DelState(result.Key)
+			if err != nil {
+				return 0, errors.New("unable to interact with world state")
+			}
+			continue
+		}
+
+		// a purge deletes like Delete, children linked with a cascading type go too
+		cascaded := []string{}
+		if namespace == "" {
+			keys, purgeable, err := purgeTree(ctx, key, purged)
+			if err != nil {
+				return 0, err
+			}
+			// a record with restricting or frozen children stays, its index entry is kept for a later purge
+			if !purgeable {
+				continue
+			}
+			for _, child := range keys[1:] {
+				childData, err := readRecord(ctx, "", child)
+				if err != nil {
+					return 0, err
+				}
+				err = purgeLinkedRecord(ctx, child, childData)
+				if err != nil {
+					return 0, err
+				}
+				purged[child] = true
+				cascaded = append(cascaded, child)
+			}
+			err = purgeLinkedRecord(ctx, key, recordData)
+			if err != nil {
+				return 0, err
+			}
+			purged[key] = true
+		} else {
+			err = removeRecord(ctx, namespace, key, recordData)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		event, err := newRecordEvent(ctx, RecordDeletedEvent, key, recordData, []string{})
+		if err != nil {
+			return 0, err
+		}
+		event.Cascaded = cascaded
+		events = append(events, *event)
+	}
+
+	if len(events) > 0 {
+		err = emitBatchEvent(ctx, events)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(events), nil
+}
+
+// isExpired reports whether the expiresAt of a record is not after the transaction timestamp
+func isExpired(ctx contractapi.TransactionContextInterface, recordData *RecordData) (bool, error) {
+	if recordData.ExpiresAt == "" {
+		return false, nil
+	}
+	expiresAt, err := parseExpiresAt(recordData.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return !expiresAt.After(now), nil
+}
+
+func parseExpiresAt(expiresAt string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339Nano, expiresAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expiresAt %s is not an RFC 3339 timestamp", expiresAt)
+	}
+
+	return parsed, nil
+}
+
+// isLive reports whether a record exists and is neither soft-deleted nor expired
+func isLive(ctx contractapi.TransactionContextInterface, recordData *RecordData) (bool, error) {
+	if recordData == nil || recordData.Tombstone != nil {
+		return false, nil
+	}
+	expired, err := isExpired(ctx, recordData)
+	if err != nil {
+		return false, err
+	}
+
+	return !expired, nil
+}
+
+// updateExpiryIndex moves the expiry index entry of key in namespace from the previous expiresAt to the current one,
+// an empty expiresAt means the record does not expire
+func updateExpiryIndex(ctx contractapi.TransactionContextInterface, namespace string, key string, previous string, current string) error {
+	if previous == current {
+		return nil
+	}
+	if previous != "" {
+		indexKey, err := expiryIndexKey(ctx, namespace, key, previous)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(indexKey)
+		if err != nil {
+			return errors.New("unable to interact with world state")
+		}
+	}
+	if current != "" {
+		indexKey, err := expiryIndexKey(ctx, namespace, key, current)
+		if err != nil {
+			return err
+		}
+		// the index only needs the key, an empty value would be treated as a delete
+		err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+		if err != nil {
+			return errors.New("unable to interact with world state")
+		}
+	}
+
+	return nil
+}
+
+func expiryIndexKey(ctx contractapi.TransactionContextInterface, namespace string, key string, expiresAt string) (string, error) {
+	parsed, err := parseExpiresAt(expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return ctx.GetStub().CreateCompositeKey(expiryPrefix, []string{parsed.UTC().Format(expiryTimeFormat), namespace, key})
+}
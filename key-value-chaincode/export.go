@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/smallverse/hyperledger-fabric-v2-kubernetes-dev/key-value-chaincode/merkle"
+)
+
+// An export walks the records outside any namespace first and then the namespaced ones,
+// its bookmarks carry the phase in front of the bookmark of the underlying query.
+const (
+	exportPlainPhase      = "plain:"
+	exportNamespacedPhase = "namespaced:"
+)
+
+// ExportedRecord is one line of an export, Key is the key the record is stored at
+type ExportedRecord struct {
+	Namespace string     `json:"namespace,omitempty" metadata:",optional"`
+	Key       string     `json:"key"`
+	Record    RecordData `json:"record"`
+}
+
+// ExportedPage is one page of an export. Lines holds one canonical ExportedRecord JSON per line and
+// Digest is the hex SHA-256 of Lines.
+type ExportedPage struct {
+	Lines               string `json:"lines"`
+	Digest              string `json:"digest"`
+	FetchedRecordsCount int32  `json:"fetchedRecordsCount"`
+	Bookmark            string `json:"bookmark"`
+}
+
+// ImportResult reports an imported page, Digest is computed like the digest of an exported page
+type ImportResult struct {
+	Imported int    `json:"imported"`
+	Digest   string `json:"digest"`
+}
+
+// ExportPage exports the records of every namespace, tombstones and expired records included, pageSize at
+// a time. Pass the bookmark of the previous page to continue, the export is complete when it is empty.
+// Records are exported as stored, with their version, tx id, ownership and ACL. Settings, namespaces,
+// link types, links and uploaded chunks are not exported and must be set up again before an import.
+func (sc *KeyValueContract) ExportPage(ctx contractapi.TransactionContextInterface, bookmark string, pageSize int32) (*ExportedPage, error) {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	phase := exportPlainPhase
+	if strings.HasPrefix(bookmark, exportNamespacedPhase) {
+		phase = exportNamespacedPhase
+	} else if bookmark != "" && !strings.HasPrefix(bookmark, exportPlainPhase) {
+		return nil, fmt.Errorf("bookmark %s is not an export bookmark", bookmark)
+	}
+	iterator, nextBookmark, err := exportQuery(ctx, phase, strings.TrimPrefix(bookmark, phase), pageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	var lines bytes.Buffer
+	var count int32
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+
+		exported := ExportedRecord{Key: result.Key}
+		if phase == exportNamespacedPhase {
+			_, attributes, err := ctx.GetStub().SplitCompositeKey(result.Key)
+			if err != nil {
+				return nil, err
+			}
+			exported.Namespace = attributes[0]
+			exported.Key = attributes[1]
+		}
+		// a migration must not lose records silently
+		err = json.Unmarshal(result.Value, &exported.Record)
+		if err != nil {
+			return nil, fmt.Errorf("record at key %s is not valid JSON: %v", result.Key, err)
+		}
+		line, err := exportLine(&exported)
+		if err != nil {
+			return nil, err
+		}
+		lines.Write(line)
+		lines.WriteByte('\n')
+		count++
+	}
+
+	page := ExportedPage{
+		Lines:               lines.String(),
+		Digest:              linesDigest(lines.Bytes()),
+		FetchedRecordsCount: count,
+	}
+	if nextBookmark != "" {
+		page.Bookmark = phase + nextBookmark
+	} else if phase == exportPlainPhase {
+		page.Bookmark = exportNamespacedPhase
+	}
+
+	return &page, nil
+}
+
+// ImportPage recreates the records of a page exported by ExportPage, keeping their version, tx id,
+// ownership and ACL. It fails if any record already exists, so a page is imported once or not at all.
+// The digest of the result matches the digest of the exported page, and exporting the imported
+// records again yields the same lines.
+func (sc *KeyValueContract) ImportPage(ctx contractapi.TransactionContextInterface, linesJSON string) (*ImportResult, error) {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines bytes.Buffer
+	events := []RecordEvent{}
+	// the world state does not show the writes of this transaction, so duplicates in the page are tracked here
+	imported := map[string]bool{}
+	namespaces := map[string]bool{"": true}
+	for i, line := range strings.Split(linesJSON, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var exported ExportedRecord
+		err = json.Unmarshal([]byte(line), &exported)
+		if err != nil {
+			log.Printf("failed to json.Unmarshal line %d in ImportPage: %v", i+1, err)
+			return nil, fmt.Errorf("line %d is not valid JSON: %v", i+1, err)
+		}
+		if exported.Key == "" || exported.Record.Version < 1 {
+			return nil, fmt.Errorf("line %d is not an exported record", i+1)
+		}
+
+		if !namespaces[exported.Namespace] {
+			settings, err := readNamespaceSettings(ctx, exported.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			if settings == nil {
+				return nil, fmt.Errorf("namespace %s does not exist, create it before the import", exported.Namespace)
+			}
+			namespaces[exported.Namespace] = true
+		}
+		stateKey, err := recordStateKey(ctx, exported.Namespace, exported.Key)
+		if err != nil {
+			return nil, err
+		}
+		existing, err := readRecord(ctx, exported.Namespace, exported.Key)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil || imported[stateKey] {
+			return nil, fmt.Errorf("cannot import world state pair with key %s in namespace %q. Already exists", exported.Key, exported.Namespace)
+		}
+		imported[stateKey] = true
+
+		recordData := exported.Record
+		recordData.Namespace = exported.Namespace
+		event, err := writeRecord(ctx, exported.Key, &recordData, nil)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+
+		canonicalLine, err := exportLine(&ExportedRecord{Namespace: exported.Namespace, Key: exported.Key, Record: recordData})
+		if err != nil {
+			return nil, err
+		}
+		lines.Write(canonicalLine)
+		lines.WriteByte('\n')
+	}
+
+	if len(events) > 0 {
+		err = emitBatchEvent(ctx, events)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ImportResult{
+		Imported: len(events),
+		Digest:   linesDigest(lines.Bytes()),
+	}, nil
+}
+
+// exportQuery returns the records of a phase of an export and the bookmark of the query for the next page
+func exportQuery(ctx contractapi.TransactionContextInterface, phase string, bookmark string, pageSize int32) (shim.StateQueryIteratorInterface, string, error) {
+	if phase == exportNamespacedPhase {
+		iterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(recordPrefix, []string{}, pageSize, bookmark)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to export namespaced records: %v", err)
+		}
+		return iterator, metadata.Bookmark, nil
+	}
+
+	// a range query skips composite keys, which leaves the records outside any namespace
+	iterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to export records: %v", err)
+	}
+
+	return iterator, metadata.Bookmark, nil
+}
+
+func exportLine(exported *ExportedRecord) ([]byte, error) {
+	var exportedBytes []byte
+	exportedBytes, _ = json.Marshal(exported)
+
+	return merkle.Canonicalize(exportedBytes)
+}
+
+func linesDigest(lines []byte) string {
+	digest := sha256.Sum256(lines)
+
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define objectType names for prefix
+const freezePrefix = "freeze"
+
+// RecordFreeze keeps a record from being modified or deleted, e.g. while it is under legal hold.
+// ByAdmin is set when the admin froze the record, then only the admin can unfreeze it.
+type RecordFreeze struct {
+	Namespace   string    `json:"namespace,omitempty" metadata:",optional"`
+	Key         string    `json:"key"`
+	Reason      string    `json:"reason"`
+	FrozenBy    string    `json:"frozenBy"`
+	FrozenByMSP string    `json:"frozenByMSP"`
+	ByAdmin     bool      `json:"byAdmin"`
+	FrozenAt    time.Time `json:"frozenAt"`
+	TxID        string    `json:"txId"`
+}
+
+// Freeze rejects every change and delete of the record at key until Unfreeze, only its owner or the admin may freeze it
+func (sc *KeyValueContract) Freeze(ctx contractapi.TransactionContextInterface, key string, reason string) error {
+	return freezeRecord(ctx, "", key, reason)
+}
+
+// Unfreeze lets the record at key be modified again
+func (sc *KeyValueContract) Unfreeze(ctx contractapi.TransactionContextInterface, key string) error {
+	return unfreezeRecord(ctx, "", key)
+}
+
+// FreezeInNamespace freezes the record with key in a namespace, see Freeze
+func (sc *KeyValueContract) FreezeInNamespace(ctx contractapi.TransactionContextInterface, namespace string, key string, reason string) error {
+	err := requireNamespace(namespace)
+	if err != nil {
+		return err
+	}
+
+	return freezeRecord(ctx, namespace, key, reason)
+}
+
+// UnfreezeInNamespace unfreezes the record with key in a namespace
+func (sc *KeyValueContract) UnfreezeInNamespace(ctx contractapi.TransactionContextInterface, namespace string, key string) error {
+	err := requireNamespace(namespace)
+	if err != nil {
+		return err
+	}
+
+	return unfreezeRecord(ctx, namespace, key)
+}
+
+// ListFrozen returns the freezes of the records in a namespace ordered by key, an empty namespace lists
+// the records outside any namespace
+func (sc *KeyValueContract) ListFrozen(ctx contractapi.TransactionContextInterface, namespace string) ([]RecordFreeze, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(freezePrefix, []string{namespace})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list frozen keys of namespace %s: %v", namespace, err)
+	}
+	defer iterator.Close()
+
+	freezes := []RecordFreeze{}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+
+		var freeze RecordFreeze
+		err = json.Unmarshal(result.Value, &freeze)
+		if err != nil {
+			log.Printf("failed to json.Unmarshal value of key %s: %v", result.Key, err)
+			continue
+		}
+		freezes = append(freezes, freeze)
+	}
+
+	return freezes, nil
+}
+
+func freezeRecord(ctx contractapi.TransactionContextInterface, namespace string, key string, reason string) error {
+	recordData, err := readRecord(ctx, namespace, key)
+	if err != nil {
+		return err
+	}
+	live, err := isLive(ctx, recordData)
+	if err != nil {
+		return err
+	}
+	if !live {
+		return fmt.Errorf("cannot freeze world state pair with key %s. Does not exist", key)
+	}
+	if reason == "" {
+		return errors.New("a freeze needs a reason")
+	}
+	existing, err := readFreeze(ctx, namespace, key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("key %s is already frozen: %s", key, existing.Reason)
+	}
+	err = checkAccessPolicy(ctx, namespace, AccessWrite)
+	if err != nil {
+		return err
+	}
+	byAdmin, err := checkOwnerOrAdmin(ctx, key, recordData)
+	if err != nil {
+		return err
+	}
+
+	frozenBy, frozenByMSP, err := clientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	frozenAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	freeze := RecordFreeze{
+		Namespace:   namespace,
+		Key:         key,
+		Reason:      reason,
+		FrozenBy:    frozenBy,
+		FrozenByMSP: frozenByMSP,
+		ByAdmin:     byAdmin,
+		FrozenAt:    frozenAt,
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	freezeKey, err := ctx.GetStub().CreateCompositeKey(freezePrefix, []string{namespace, key})
+	if err != nil {
+		return err
+	}
+	var freezeBytes []byte
+	freezeBytes, _ = json.Marshal(freeze)
+
+	return ctx.GetStub().PutState(freezeKey, freezeBytes)
+}
+
+func unfreezeRecord(ctx contractapi.TransactionContextInterface, namespace string, key string) error {
+	freeze, err := readFreeze(ctx, namespace, key)
+	if err != nil {
+		return err
+	}
+	if freeze == nil {
+		return fmt.Errorf("key %s is not frozen", key)
+	}
+	err = checkAccessPolicy(ctx, namespace, AccessWrite)
+	if err != nil {
+		return err
+	}
+	recordData, err := readRecord(ctx, namespace, key)
+	if err != nil {
+		return err
+	}
+	byAdmin := true
+	if recordData != nil {
+		byAdmin, err = checkOwnerOrAdmin(ctx, key, recordData)
+	} else {
+		err = requireAdmin(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if freeze.ByAdmin && !byAdmin {
+		return fmt.Errorf("key %s was frozen by the admin, only the admin can unfreeze it", key)
+	}
+
+	freezeKey, err := ctx.GetStub().CreateCompositeKey(freezePrefix, []string{namespace, key})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(freezeKey)
+}
+
+// checkNotFrozen fails with the reason of the freeze if the record with key in namespace is frozen
+func checkNotFrozen(ctx contractapi.TransactionContextInterface, namespace string, key string) error {
+	freeze, err := readFreeze(ctx, namespace, key)
+	if err != nil {
+		return err
+	}
+	if freeze != nil {
+		return fmt.Errorf("cannot modify key %s, it is frozen since %s: %s", key, freeze.FrozenAt.Format(time.RFC3339), freeze.Reason)
+	}
+
+	return nil
+}
+
+// checkOwnerOrAdmin allows the admin and the owner of a record, it reports whether the client acts as admin
+func checkOwnerOrAdmin(ctx contractapi.TransactionContextInterface, key string, recordData *RecordData) (bool, error) {
+	if requireAdmin(ctx) == nil {
+		return true, nil
+	}
+	clientID, clientMSPID, err := clientIdentity(ctx)
+	if err != nil {
+		return false, err
+	}
+	if recordData.Owner != "" && clientID == recordData.Owner && clientMSPID == recordData.OwnerMSP {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("client is neither the owner of key %s nor the admin", key)
+}
+
+func readFreeze(ctx contractapi.TransactionContextInterface, namespace string, key string) (*RecordFreeze, error) {
+	freezeKey, err := ctx.GetStub().CreateCompositeKey(freezePrefix, []string{namespace, key})
+	if err != nil {
+		return nil, err
+	}
+	freezeBytes, err := ctx.GetStub().GetState(freezeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read freeze of key %s: %v", key, err)
+	}
+	if freezeBytes == nil {
+		return nil, nil
+	}
+
+	var freeze RecordFreeze
+	err = json.Unmarshal(freezeBytes, &freeze)
+	if err != nil {
+		return nil, err
+	}
+
+	return &freeze, nil
+}
@@ -3,6 +3,8 @@ module github.com/smallverse/hyperledger-fabric-v2-kubernetes-dev/key-value-chai
 go 1.16
 
 require (
-	github.com/hyperledger/fabric-chaincode-go v0.0.0-20210718160520-38d29fabecb9 // indirect
+	github.com/evanphx/json-patch/v5 v5.6.0
+	github.com/hyperledger/fabric-chaincode-go v0.0.0-20210718160520-38d29fabecb9
 	github.com/hyperledger/fabric-contract-api-go v1.1.1
+	github.com/xeipuuv/gojsonschema v1.2.0
 )
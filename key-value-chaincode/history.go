@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// RecordHistoryEntry is one past version of a record as kept by the peer's history database
+type RecordHistoryEntry struct {
+	TxID      string      `json:"txId"`
+	Timestamp time.Time   `json:"timestamp"`
+	IsDelete  bool        `json:"isDelete"`
+	Record    *RecordData `json:"record,omitempty" metadata:",optional"`
+}
+
+// History returns every version of the record at key, most recent first
+func (sc *KeyValueContract) History(ctx contractapi.TransactionContextInterface, key string) ([]RecordHistoryEntry, error) {
+	err := checkAccessPolicy(ctx, "", AccessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	return readHistory(ctx, key)
+}
+
+// ReadAt returns the given version of the record at key from the peer's history database.
+// If the key was deleted and created again, the version of the most recent record is returned.
+func (sc *KeyValueContract) ReadAt(ctx contractapi.TransactionContextInterface, key string, version int) (*RecordHistoryEntry, error) {
+	err := checkAccessPolicy(ctx, "", AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readHistory(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		if entries[i].Record != nil && entries[i].Record.Version == version {
+			return &entries[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("version %d of key %s does not exist", version, key)
+}
+
+// ReadAsOf returns the version of the record at key that was in effect at an RFC 3339 timestamp,
+// i.e. the last version committed at or before it. It fails if the record was deleted, soft-deleted
+// or expired at that time.
+func (sc *KeyValueContract) ReadAsOf(ctx contractapi.TransactionContextInterface, key string, timestamp string) (*RecordHistoryEntry, error) {
+	err := checkAccessPolicy(ctx, "", AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp %s is not an RFC 3339 timestamp", timestamp)
+	}
+	entries, err := readHistory(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		if entries[i].Timestamp.After(asOf) {
+			continue
+		}
+		recordData := entries[i].Record
+		if recordData == nil || recordData.Tombstone != nil {
+			break
+		}
+		if recordData.ExpiresAt != "" {
+			expiresAt, err := parseExpiresAt(recordData.ExpiresAt)
+			if err != nil {
+				return nil, err
+			}
+			if !expiresAt.After(asOf) {
+				break
+			}
+		}
+		return &entries[i], nil
+	}
+
+	return nil, fmt.Errorf("key %s did not exist at %s", key, timestamp)
+}
+
+// readHistory returns every version of the record at key, most recent first
+func readHistory(ctx contractapi.TransactionContextInterface, key string) ([]RecordHistoryEntry, error) {
+	stateKey, err := recordStateKey(ctx, "", key)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := ctx.GetStub().GetHistoryForKey(stateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read history for key %s: %v", key, err)
+	}
+	defer iterator.Close()
+
+	entries := []RecordHistoryEntry{}
+	for iterator.HasNext() {
+		modification, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read history for key %s: %v", key, err)
+		}
+
+		entry := RecordHistoryEntry{
+			TxID:     modification.TxId,
+			IsDelete: modification.IsDelete,
+		}
+		if ts := modification.Timestamp; ts != nil {
+			entry.Timestamp = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
+		}
+		if !modification.IsDelete && len(modification.Value) > 0 {
+			var recordData RecordData
+			err = json.Unmarshal(modification.Value, &recordData)
+			if err != nil {
+				log.Printf("failed to json.Unmarshal history value of key %s in tx %s: %v", key, modification.TxId, err)
+				return nil, err
+			}
+			entry.Record = &recordData
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define objectType names for prefix
+const indexPrefix = "index"
+
+// Define key names for settings
+const indexedFieldsKey = "indexedFields"
+
+// nameIndexField is the index on RecordData.Name, TokenData fields are indexed as tokenData.<field>
+const nameIndexField = "name"
+
+// maxIndexedFields bounds the index entries written for every record
+const maxIndexedFields = 10
+
+// FindByName returns the records with the given name, pageSize at a time.
+// It reads the composite key index kept by the chaincode, so it works on LevelDB as well as CouchDB.
+func (sc *KeyValueContract) FindByName(ctx contractapi.TransactionContextInterface, name string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return findByIndex(ctx, "", nameIndexField, name, pageSize, bookmark)
+}
+
+// FindByTokenData returns the records whose TokenData field has the given value, pageSize at a time.
+// The field must be set with SetIndexedFields, value is a string or the JSON encoding of a number or boolean.
+func (sc *KeyValueContract) FindByTokenData(ctx contractapi.TransactionContextInterface, field string, value string, pageSize int32, bookmark string) (*RecordPage, error) {
+	fields, err := indexedFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, indexed := range fields {
+		if indexed == field {
+			return findByIndex(ctx, "", tokenDataIndexField(field), value, pageSize, bookmark)
+		}
+	}
+
+	return nil, fmt.Errorf("tokenData field %s is not indexed", field)
+}
+
+// SetIndexedFields sets the top-level TokenData fields that get an index, fieldsJSON is a JSON array of names.
+// Only records written afterwards are indexed under new fields.
+func (sc *KeyValueContract) SetIndexedFields(ctx contractapi.TransactionContextInterface, fieldsJSON string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	err = json.Unmarshal([]byte(fieldsJSON), &fields)
+	if err != nil {
+		return fmt.Errorf("indexed fields must be a JSON array of strings: %v", err)
+	}
+	if len(fields) > maxIndexedFields {
+		return fmt.Errorf("at most %d tokenData fields can be indexed", maxIndexedFields)
+	}
+	for _, field := range fields {
+		if field == "" {
+			return errors.New("indexed field names must not be empty")
+		}
+	}
+
+	fieldsKey, err := ctx.GetStub().CreateCompositeKey(settingPrefix, []string{indexedFieldsKey})
+	if err != nil {
+		return err
+	}
+	var fieldsBytes []byte
+	fieldsBytes, _ = json.Marshal(fields)
+
+	return ctx.GetStub().PutState(fieldsKey, fieldsBytes)
+}
+
+// GetIndexedFields returns the TokenData fields that get an index
+func (sc *KeyValueContract) GetIndexedFields(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return indexedFields(ctx)
+}
+
+func indexedFields(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	fieldsKey, err := ctx.GetStub().CreateCompositeKey(settingPrefix, []string{indexedFieldsKey})
+	if err != nil {
+		return nil, err
+	}
+	fieldsBytes, err := ctx.GetStub().GetState(fieldsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexed fields: %v", err)
+	}
+	fields := []string{}
+	if fieldsBytes == nil {
+		return fields, nil
+	}
+	err = json.Unmarshal(fieldsBytes, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// findByIndex returns the records of namespace whose field has value
+func findByIndex(ctx contractapi.TransactionContextInterface, namespace string, field string, value string, pageSize int32, bookmark string) (*RecordPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+	err := checkAccessPolicy(ctx, namespace, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(indexPrefix, []string{field, value, namespace}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read index %s: %v", field, err)
+	}
+	defer iterator.Close()
+
+	records := []RecordData{}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(result.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		recordData, err := readRecord(ctx, namespace, attributes[3])
+		if err != nil {
+			return nil, err
+		}
+		live, err := isLive(ctx, recordData)
+		if err != nil {
+			return nil, err
+		}
+		// entries left behind by a change of the indexed fields no longer match the record
+		if !live || !containsIndexEntry(recordIndexEntries(recordData, []string{field}), field, value) {
+			continue
+		}
+		recordData.Key = attributes[3]
+		records = append(records, *recordData)
+	}
+
+	return &RecordPage{
+		Records:             records,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// indexEntry is one field value of a record that is indexed
+type indexEntry struct {
+	field string
+	value string
+}
+
+// updateRecordIndexes replaces the index entries of key in namespace for previous with those for current,
+// either may be nil. Soft-deleted records have no index entries.
+func updateRecordIndexes(ctx contractapi.TransactionContextInterface, namespace string, key string, previous *RecordData, current *RecordData) error {
+	tokenDataFields, err := indexedFields(ctx)
+	if err != nil {
+		return err
+	}
+	fields := []string{nameIndexField}
+	for _, field := range tokenDataFields {
+		fields = append(fields, tokenDataIndexField(field))
+	}
+
+	var previousEntries, currentEntries []indexEntry
+	if previous != nil && previous.Tombstone == nil {
+		previousEntries = recordIndexEntries(previous, fields)
+	}
+	if current != nil && current.Tombstone == nil {
+		currentEntries = recordIndexEntries(current, fields)
+	}
+
+	for _, entry := range previousEntries {
+		if containsIndexEntry(currentEntries, entry.field, entry.value) {
+			continue
+		}
+		indexKey, err := ctx.GetStub().CreateCompositeKey(indexPrefix, []string{entry.field, entry.value, namespace, key})
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(indexKey)
+		if err != nil {
+			return errors.New("unable to interact with world state")
+		}
+	}
+	// entries are written even if previous had them, previous may have been written before its field was indexed
+	for _, entry := range currentEntries {
+		indexKey, err := ctx.GetStub().CreateCompositeKey(indexPrefix, []string{entry.field, entry.value, namespace, key})
+		if err != nil {
+			return fmt.Errorf("%s of key %s cannot be indexed: %v", entry.field, key, err)
+		}
+		// the index only needs the key, an empty value would be treated as a delete
+		err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+		if err != nil {
+			return errors.New("unable to interact with world state")
+		}
+	}
+
+	return nil
+}
+
+// recordIndexEntries returns the non-empty values of the given fields of a record.
+// TokenData values are indexed when they are strings, numbers or booleans.
+func recordIndexEntries(recordData *RecordData, fields []string) []indexEntry {
+	entries := []indexEntry{}
+	for _, field := range fields {
+		var value string
+		if field == nameIndexField {
+			value = recordData.Name
+		} else {
+			switch tokenDataValue := recordData.TokenData[strings.TrimPrefix(field, "tokenData.")].(type) {
+			case string:
+				value = tokenDataValue
+			case float64, bool:
+				var valueBytes []byte
+				valueBytes, _ = json.Marshal(tokenDataValue)
+				value = string(valueBytes)
+			}
+		}
+		if value != "" {
+			entries = append(entries, indexEntry{field: field, value: value})
+		}
+	}
+	return entries
+}
+
+func containsIndexEntry(entries []indexEntry, field string, value string) bool {
+	for _, entry := range entries {
+		if entry.field == field && entry.value == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tokenDataIndexField(field string) string {
+	return "tokenData." + field
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"log"
+	"time"
 )
 
 // KeyValueContract contract for handling writing and reading from the world state
@@ -13,78 +14,504 @@ type KeyValueContract struct {
 	contractapi.Contract
 }
 
-const TokenDataLen = 100
+// Define objectType names for prefix
+const settingPrefix = "setting"
 
 //（注意json格式）
 type RecordData struct {
 	Key         string                 `json:"key"`
-	TokenData   map[string]interface{} `json:"tokenData,omitempty"`
+	TokenData   map[string]interface{} `json:"tokenData,omitempty" metadata:",optional"`
 	Name        string                 `json:"name"`
-	Description string                 `json:"description,omitempty"`
+	Type        string                 `json:"type,omitempty" metadata:",optional"`
+	Description string                 `json:"description,omitempty" metadata:",optional"`
+	Tombstone   *Tombstone             `json:"tombstone,omitempty" metadata:",optional"`
+	Version     int                    `json:"version"`
+	TxID        string                 `json:"txId,omitempty" metadata:",optional"`
+	Owner       string                 `json:"owner,omitempty" metadata:",optional"`
+	OwnerMSP    string                 `json:"ownerMSP,omitempty" metadata:",optional"`
+	ACL         *RecordACL             `json:"acl,omitempty" metadata:",optional"`
+	Namespace   string                 `json:"namespace,omitempty" metadata:",optional"`
+	Encrypted   *EncryptedPayload      `json:"encrypted,omitempty" metadata:",optional"`
+	ExpiresAt   string                 `json:"expiresAt,omitempty" metadata:",optional"`
+	Content     *ContentRef            `json:"content,omitempty" metadata:",optional"`
+	Signature   *RecordSignature       `json:"signature,omitempty" metadata:",optional"`
 }
 
-// Create adds a new key with value to the world state
+// Create adds a new key with value to the world state.
+// A value signed by a registered device carries the signature under "signature", see RegisterDevice.
+// A signed value must hold the key and the version it creates, so that it cannot be replayed at another key or version.
 func (sc *KeyValueContract) Create(ctx contractapi.TransactionContextInterface, key string, value string) (string, error) {
-	existing, err := ctx.GetStub().GetState(key)
+	event, err := createData(ctx, "", key, value)
 	if err != nil {
-		return key, fmt.Errorf("unable to interact with world state,key %s", key)
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+func createData(ctx contractapi.TransactionContextInterface, namespace string, key string, value string) (*RecordEvent, error) {
+	existing, err := readRecord(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCreatable(ctx, key, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	return putData(ctx, namespace, key, value, existing)
+}
+
+// checkCreatable fails unless a record can be created over existing, the current record at key if any
+func checkCreatable(ctx contractapi.TransactionContextInterface, key string, existing *RecordData) error {
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	err = checkKeyPattern(config, key)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+	// a tombstone is kept for the tombstone policy alone, even after the record would have expired
+	if existing.Tombstone != nil {
+		reusable, err := tombstoneReusable(ctx, existing)
+		if err != nil {
+			return err
+		}
+		if !reusable {
+			return fmt.Errorf("cannot create world state pair with key %s. Already exists", key)
+		}
+		return nil
+	}
+	expired, err := isExpired(ctx, existing)
+	if err != nil {
+		return err
+	}
+	if !expired {
+		return fmt.Errorf("cannot create world state pair with key %s. Already exists", key)
+	}
+
+	return nil
+}
+
+// putData validates value and writes it at key in namespace, existing is the current record at key if any.
+// It returns the event describing the change.
+func putData(ctx contractapi.TransactionContextInterface, namespace string, key string, value string, existing *RecordData) (*RecordEvent, error) {
+	recordData, err := buildRecord(ctx, namespace, key, value, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeRecord(ctx, key, &recordData, existing)
+}
+
+// writeRecord writes a record built by buildRecord at key in its namespace and updates the indexes.
+// existing is the record it replaces, if any.
+func writeRecord(ctx contractapi.TransactionContextInterface, key string, recordData *RecordData, existing *RecordData) (*RecordEvent, error) {
+	namespace := recordData.Namespace
+	err := checkNotFrozen(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	stateKey, err := recordStateKey(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	var recordDataBytes []byte
+	recordDataBytes, _ = json.Marshal(recordData)
+	err = ctx.GetStub().PutState(stateKey, recordDataBytes)
+
+	if err != nil {
+		return nil, errors.New("unable to interact with world state")
 	}
+
+	previousExpiresAt := ""
 	if existing != nil {
-		return key, fmt.Errorf("cannot create world state pair with key %s. Already exists", key)
+		previousExpiresAt = existing.ExpiresAt
+	}
+	err = updateExpiryIndex(ctx, namespace, key, previousExpiresAt, recordData.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	err = updateRecordIndexes(ctx, namespace, key, existing, recordData)
+	if err != nil {
+		return nil, err
 	}
 
-	return putData(ctx, key, value, err)
+	if existing == nil || existing.Tombstone != nil {
+		return newRecordEvent(ctx, RecordCreatedEvent, key, recordData, changedFields(nil, recordData))
+	}
+	return newRecordEvent(ctx, RecordUpdatedEvent, key, recordData, changedFields(existing, recordData))
 }
 
-func putData(ctx contractapi.TransactionContextInterface, key string, value string, err error) (string, error) {
+// buildRecord decodes and validates value for the record at key in namespace and fills in the fields managed by the chaincode.
+// previous is the record value replaces, nil for a new record.
+func buildRecord(ctx contractapi.TransactionContextInterface, namespace string, key string, value string, previous *RecordData) (RecordData, error) {
+	settings, err := checkNamespaceWriter(ctx, namespace)
+	if err != nil {
+		return RecordData{}, err
+	}
+	err = checkAccessPolicy(ctx, namespace, AccessWrite)
+	if err != nil {
+		return RecordData{}, err
+	}
+
 	var recordData RecordData
 	err = json.Unmarshal([]byte(value), &recordData)
 	if err != nil {
 		log.Printf("failed to json.Unmarshal([]byte(value), &recordData) in Create: %v", err)
-		return key, err
+		return RecordData{}, err
 	}
 
-	if len(recordData.TokenData) > TokenDataLen {
-		err = errors.New("len(recordData.TokenData) > (TokenDataLen=100),len is must <= 100")
+	config, err := readConfig(ctx)
+	if err != nil {
+		return RecordData{}, err
+	}
+	if config.MaxAttributes > 0 && len(recordData.TokenData) > config.MaxAttributes {
+		err = fmt.Errorf("len(recordData.TokenData) > %d, the maxAttributes of the config", config.MaxAttributes)
 		log.Print(err)
-		return key, err
+		return RecordData{}, err
+	}
+	err = checkJSONLimits(config, value)
+	if err != nil {
+		log.Print(err)
+		return RecordData{}, err
+	}
+	err = validateRecordType(ctx, &recordData)
+	if err != nil {
+		log.Print(err)
+		return RecordData{}, err
+	}
+	if settings != nil {
+		err = validateNamespaceRecord(settings, &recordData)
+		if err != nil {
+			log.Print(err)
+			return RecordData{}, err
+		}
 	}
 
-	var recordDataBytes []byte
-	recordDataBytes, _ = json.Marshal(recordData)
-	err = ctx.GetStub().PutState(key, recordDataBytes)
+	expired, err := isExpired(ctx, &recordData)
+	if err != nil {
+		return RecordData{}, err
+	}
+	if expired {
+		return RecordData{}, fmt.Errorf("expiresAt %s is not after the transaction timestamp", recordData.ExpiresAt)
+	}
 
+	// namespace, version, tx id, ownership, tombstone and encryption are managed by the chaincode only
+	recordData.Namespace = namespace
+	recordData.Version = 1
+	recordData.TxID = ctx.GetStub().GetTxID()
+	recordData.Tombstone = nil
+	recordData.Encrypted = nil
+	if previous != nil {
+		recordData.Version = previous.Version + 1
+	}
+	live, err := isLive(ctx, previous)
+	if err != nil {
+		return RecordData{}, err
+	}
+	if live {
+		recordData.Owner = previous.Owner
+		recordData.OwnerMSP = previous.OwnerMSP
+		recordData.ACL = previous.ACL
+	} else {
+		recordData.Owner, recordData.OwnerMSP, err = clientIdentity(ctx)
+		if err != nil {
+			return RecordData{}, err
+		}
+		recordData.ACL = nil
+		// a dead record has no content to keep
+		previous = nil
+	}
+	err = validateContent(&recordData, previous)
 	if err != nil {
-		return key, errors.New("unable to interact with world state")
+		return RecordData{}, err
+	}
+	err = verifyRecordSignature(ctx, key, value, &recordData)
+	if err != nil {
+		return RecordData{}, err
 	}
 
-	return key, nil
+	return recordData, nil
 }
 
-// Update changes the value with key in the world state
+// Update changes the value with key in the world state, value may be signed by a device like in Create.
+// An encrypted record cannot be updated, it must be deleted and created again with CreateEncrypted.
 func (sc *KeyValueContract) Update(ctx contractapi.TransactionContextInterface, key string, value string) (string, error) {
-	existing, err := ctx.GetStub().GetState(key)
+	event, err := updateData(ctx, "", key, value)
 	if err != nil {
-		return key, fmt.Errorf("unable to interact with world state,key %s", key)
+		return key, err
 	}
-	if existing == nil {
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+func updateData(ctx contractapi.TransactionContextInterface, namespace string, key string, value string) (*RecordEvent, error) {
+	existing, err := readRecord(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	live, err := isLive(ctx, existing)
+	if err != nil {
+		return nil, err
+	}
+	if !live {
+		return nil, fmt.Errorf("cannot update world state pair with key %s. Does not exist", key)
+	}
+	err = checkWriteAccess(ctx, key, existing)
+	if err != nil {
+		return nil, err
+	}
+	// a plaintext value would replace the ciphertext and expose the record on every peer
+	if existing.Encrypted != nil {
+		return nil, fmt.Errorf("cannot update world state pair with key %s. It is encrypted", key)
+	}
+
+	return putData(ctx, namespace, key, value, existing)
+}
+
+// UpdateIfVersion changes the value with key only if the stored record is still at expectedVersion
+func (sc *KeyValueContract) UpdateIfVersion(ctx contractapi.TransactionContextInterface, key string, expectedVersion int, value string) (string, error) {
+	existing, err := readRecord(ctx, "", key)
+	if err != nil {
+		return key, err
+	}
+	live, err := isLive(ctx, existing)
+	if err != nil {
+		return key, err
+	}
+	if !live {
 		return key, fmt.Errorf("cannot update world state pair with key %s. Does not exist", key)
 	}
+	err = checkWriteAccess(ctx, key, existing)
+	if err != nil {
+		return key, err
+	}
+	if existing.Encrypted != nil {
+		return key, fmt.Errorf("cannot update world state pair with key %s. It is encrypted", key)
+	}
+
+	if existing.Version != expectedVersion {
+		return key, fmt.Errorf("version conflict on key %s: expected version %d but current version is %d", key, expectedVersion, existing.Version)
+	}
 
-	return putData(ctx, key, value, err)
+	event, err := putData(ctx, "", key, value, existing)
+	if err != nil {
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
 }
 
 // Read returns the value at key in the world state
 func (sc *KeyValueContract) Read(ctx contractapi.TransactionContextInterface, key string) (string, error) {
-	existing, err := ctx.GetStub().GetState(key)
+	err := checkAccessPolicy(ctx, "", AccessRead)
+	if err != nil {
+		return "", err
+	}
+	stateKey, err := recordStateKey(ctx, "", key)
+	if err != nil {
+		return "", err
+	}
+	existing, err := ctx.GetStub().GetState(stateKey)
 
 	if err != nil {
 		return "", errors.New("unable to interact with world state")
 	}
 
-	if existing == nil {
+	if existing == nil || isTombstone(existing) {
 		return "", fmt.Errorf("cannot read world state pair with key %s. Does not exist", key)
 	}
+	var recordData RecordData
+	if json.Unmarshal(existing, &recordData) == nil {
+		expired, err := isExpired(ctx, &recordData)
+		if err != nil {
+			return "", err
+		}
+		if expired {
+			return "", fmt.Errorf("cannot read world state pair with key %s. Does not exist", key)
+		}
+	}
 
 	return string(existing), nil
 }
+
+// Delete removes the key from the world state
+func (sc *KeyValueContract) Delete(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	event, err := deleteData(ctx, "", key)
+	if err != nil {
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+func deleteData(ctx contractapi.TransactionContextInterface, namespace string, key string) (*RecordEvent, error) {
+	return deleteLinkedData(ctx, namespace, key, map[string]bool{})
+}
+
+// deleteLinkedData deletes the record at key in namespace and, as their link types require, its children.
+// deleted holds the keys deleted so far in the transaction.
+func deleteLinkedData(ctx contractapi.TransactionContextInterface, namespace string, key string, deleted map[string]bool) (*RecordEvent, error) {
+	existing, err := readRecord(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("cannot delete world state pair with key %s. Does not exist", key)
+	}
+	_, err = checkNamespaceWriter(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAccessPolicy(ctx, namespace, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	err = checkWriteAccess(ctx, key, existing)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotFrozen(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	// links only exist between records outside any namespace
+	cascaded := []string{}
+	if namespace == "" {
+		deleted[key] = true
+		cascaded, err = cascadeDelete(ctx, key, true, "", deleted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = removeRecord(ctx, namespace, key, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := newRecordEvent(ctx, RecordDeletedEvent, key, existing, []string{})
+	if err != nil {
+		return nil, err
+	}
+	event.Cascaded = cascaded
+
+	return event, nil
+}
+
+// removeRecord deletes existing, the record at key in namespace, its index entries and uploaded chunks without any checks
+func removeRecord(ctx contractapi.TransactionContextInterface, namespace string, key string, existing *RecordData) error {
+	stateKey, err := recordStateKey(ctx, namespace, key)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(stateKey)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+	err = updateExpiryIndex(ctx, namespace, key, existing.ExpiresAt, "")
+	if err != nil {
+		return err
+	}
+	err = updateRecordIndexes(ctx, namespace, key, existing, nil)
+	if err != nil {
+		return err
+	}
+	// content is only uploaded for records outside any namespace
+	if namespace != "" {
+		return nil
+	}
+
+	return deleteRecordChunks(ctx, key, existing)
+}
+
+// readRecord returns the record at key in namespace, nil if there is none
+func readRecord(ctx contractapi.TransactionContextInterface, namespace string, key string) (*RecordData, error) {
+	stateKey, err := recordStateKey(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := ctx.GetStub().GetState(stateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to interact with world state,key %s", key)
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	var recordData RecordData
+	err = json.Unmarshal(existing, &recordData)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal value of key %s: %v", key, err)
+		return nil, err
+	}
+
+	return &recordData, nil
+}
+
+// storeRecord writes a record changed by the chaincode itself, bumping its version
+func storeRecord(ctx contractapi.TransactionContextInterface, key string, recordData *RecordData) error {
+	err := checkNotFrozen(ctx, recordData.Namespace, key)
+	if err != nil {
+		return err
+	}
+	recordData.Version++
+	recordData.TxID = ctx.GetStub().GetTxID()
+
+	stateKey, err := recordStateKey(ctx, recordData.Namespace, key)
+	if err != nil {
+		return err
+	}
+	var recordDataBytes []byte
+	recordDataBytes, _ = json.Marshal(recordData)
+	err = ctx.GetStub().PutState(stateKey, recordDataBytes)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+
+	return nil
+}
+
+func requireAdmin(ctx contractapi.TransactionContextInterface) error {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get MSPID: %v", err)
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if clientMSPID != config.AdminMSP {
+		return fmt.Errorf("client is not authorized to administer the key-value chaincode")
+	}
+
+	return nil
+}
+
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// clientIdentity returns the id and MSP id of the submitting client
+func clientIdentity(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get client id: %v", err)
+	}
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get MSPID: %v", err)
+	}
+
+	return clientID, clientMSPID, nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define objectType names for prefix
+const (
+	linkTypePrefix = "linkType"
+	linkOutPrefix  = "linkOut"
+	linkInPrefix   = "linkIn"
+)
+
+// Link policies decide what happens to the children of a record that is deleted or soft-deleted
+const (
+	// LinkPolicyRestrict makes the delete fail while the record has live children of the type
+	LinkPolicyRestrict = "restrict"
+	// LinkPolicyCascade deletes the children of the type together with the record
+	LinkPolicyCascade = "cascade"
+)
+
+// LinkType is a kind of link between records, e.g. parentOf or derivedFrom
+type LinkType struct {
+	Name     string `json:"name"`
+	OnDelete string `json:"onDelete"`
+	TxID     string `json:"txId"`
+}
+
+// RecordLink links the record at Parent to the record at Child
+type RecordLink struct {
+	Type         string `json:"type"`
+	Parent       string `json:"parent"`
+	Child        string `json:"child"`
+	CreatedBy    string `json:"createdBy"`
+	CreatedByMSP string `json:"createdByMSP"`
+	TxID         string `json:"txId"`
+}
+
+// RegisterLinkType registers or replaces a link type, onDelete is restrict or cascade
+func (sc *KeyValueContract) RegisterLinkType(ctx contractapi.TransactionContextInterface, name string, onDelete string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("link type must not be empty")
+	}
+	if onDelete != LinkPolicyRestrict && onDelete != LinkPolicyCascade {
+		return fmt.Errorf("unknown link policy %s, must be %s or %s", onDelete, LinkPolicyRestrict, LinkPolicyCascade)
+	}
+
+	linkType := LinkType{
+		Name:     name,
+		OnDelete: onDelete,
+		TxID:     ctx.GetStub().GetTxID(),
+	}
+	linkTypeKey, err := ctx.GetStub().CreateCompositeKey(linkTypePrefix, []string{name})
+	if err != nil {
+		return err
+	}
+	var linkTypeBytes []byte
+	linkTypeBytes, _ = json.Marshal(linkType)
+
+	return ctx.GetStub().PutState(linkTypeKey, linkTypeBytes)
+}
+
+// ReadLinkType returns a registered link type
+func (sc *KeyValueContract) ReadLinkType(ctx contractapi.TransactionContextInterface, name string) (*LinkType, error) {
+	linkType, err := readLinkType(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if linkType == nil {
+		return nil, fmt.Errorf("unknown link type %s", name)
+	}
+
+	return linkType, nil
+}
+
+// Link adds a link of a registered type from the record at parentKey to the record at childKey.
+// The client must be allowed to modify both records, since deleting the parent may delete the child.
+func (sc *KeyValueContract) Link(ctx contractapi.TransactionContextInterface, linkType string, parentKey string, childKey string) error {
+	registered, err := readLinkType(ctx, linkType)
+	if err != nil {
+		return err
+	}
+	if registered == nil {
+		return fmt.Errorf("unknown link type %s", linkType)
+	}
+	if parentKey == childKey {
+		return fmt.Errorf("cannot link key %s to itself", parentKey)
+	}
+	err = checkAccessPolicy(ctx, "", AccessWrite)
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{parentKey, childKey} {
+		recordData, err := readRecord(ctx, "", key)
+		if err != nil {
+			return err
+		}
+		live, err := isLive(ctx, recordData)
+		if err != nil {
+			return err
+		}
+		if !live {
+			return fmt.Errorf("cannot link world state pair with key %s. Does not exist", key)
+		}
+		err = checkWriteAccess(ctx, key, recordData)
+		if err != nil {
+			return err
+		}
+	}
+
+	outKey, err := ctx.GetStub().CreateCompositeKey(linkOutPrefix, []string{parentKey, linkType, childKey})
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(outKey)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+	if existing != nil {
+		return fmt.Errorf("key %s is already linked to key %s with type %s", parentKey, childKey, linkType)
+	}
+
+	createdBy, createdByMSP, err := clientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	link := RecordLink{
+		Type:         linkType,
+		Parent:       parentKey,
+		Child:        childKey,
+		CreatedBy:    createdBy,
+		CreatedByMSP: createdByMSP,
+		TxID:         ctx.GetStub().GetTxID(),
+	}
+
+	return putLink(ctx, &link)
+}
+
+// Unlink removes a link, the client must be allowed to modify the parent or the child
+func (sc *KeyValueContract) Unlink(ctx contractapi.TransactionContextInterface, linkType string, parentKey string, childKey string) error {
+	outKey, err := ctx.GetStub().CreateCompositeKey(linkOutPrefix, []string{parentKey, linkType, childKey})
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(outKey)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+	if existing == nil {
+		return fmt.Errorf("key %s is not linked to key %s with type %s", parentKey, childKey, linkType)
+	}
+	err = checkAccessPolicy(ctx, "", AccessWrite)
+	if err != nil {
+		return err
+	}
+
+	err = checkLinkEndAccess(ctx, parentKey)
+	if err != nil && checkLinkEndAccess(ctx, childKey) != nil {
+		return err
+	}
+
+	return deleteLink(ctx, &RecordLink{Type: linkType, Parent: parentKey, Child: childKey})
+}
+
+// Children returns the links from the record at key, linkType restricts them to one type if not empty
+func (sc *KeyValueContract) Children(ctx contractapi.TransactionContextInterface, key string, linkType string) ([]RecordLink, error) {
+	return readLinks(ctx, linkOutPrefix, key, linkType)
+}
+
+// Parents returns the links to the record at key, linkType restricts them to one type if not empty
+func (sc *KeyValueContract) Parents(ctx contractapi.TransactionContextInterface, key string, linkType string) ([]RecordLink, error) {
+	return readLinks(ctx, linkInPrefix, key, linkType)
+}
+
+// cascadeDelete applies the link policies of the record at key before it is deleted, or soft-deleted
+// with reason unless hard, and removes every link of the record. deleted holds the keys deleted so far
+// in the transaction. It returns the keys of the children deleted with the record.
+func cascadeDelete(ctx contractapi.TransactionContextInterface, key string, hard bool, reason string, deleted map[string]bool) ([]string, error) {
+	children, err := readLinks(ctx, linkOutPrefix, key, "")
+	if err != nil {
+		return nil, err
+	}
+	parents, err := readLinks(ctx, linkInPrefix, key, "")
+	if err != nil {
+		return nil, err
+	}
+
+	cascaded := []string{}
+	for _, link := range children {
+		err = deleteLink(ctx, &link)
+		if err != nil {
+			return nil, err
+		}
+		if deleted[link.Child] {
+			continue
+		}
+		child, err := readRecord(ctx, "", link.Child)
+		if err != nil {
+			return nil, err
+		}
+		live, err := isLive(ctx, child)
+		if err != nil {
+			return nil, err
+		}
+		// a soft-deleted child has no links left, an expired one is still deleted by a hard delete
+		if !live && (child == nil || !hard || child.Tombstone != nil) {
+			continue
+		}
+
+		linkType, err := readLinkType(ctx, link.Type)
+		if err != nil {
+			return nil, err
+		}
+		if linkType == nil || linkType.OnDelete != LinkPolicyCascade {
+			return nil, fmt.Errorf("cannot delete key %s, it has child %s linked with type %s", key, link.Child, link.Type)
+		}
+
+		var event *RecordEvent
+		if hard {
+			event, err = deleteLinkedData(ctx, "", link.Child, deleted)
+		} else {
+			event, err = softDeleteData(ctx, link.Child, reason, deleted)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot delete child %s of key %s: %v", link.Child, key, err)
+		}
+		cascaded = append(cascaded, link.Child)
+		cascaded = append(cascaded, event.Cascaded...)
+	}
+	for _, link := range parents {
+		err = deleteLink(ctx, &link)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cascaded, nil
+}
+
+// checkLinkEndAccess allows the client to change the links of a record it may modify, or of a missing one
+func checkLinkEndAccess(ctx contractapi.TransactionContextInterface, key string) error {
+	recordData, err := readRecord(ctx, "", key)
+	if err != nil {
+		return err
+	}
+	if recordData == nil {
+		return nil
+	}
+
+	return checkWriteAccess(ctx, key, recordData)
+}
+
+func readLinks(ctx contractapi.TransactionContextInterface, prefix string, key string, linkType string) ([]RecordLink, error) {
+	attributes := []string{key}
+	if linkType != "" {
+		attributes = append(attributes, linkType)
+	}
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(prefix, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read links of key %s: %v", key, err)
+	}
+	defer iterator.Close()
+
+	links := []RecordLink{}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+
+		var link RecordLink
+		err = json.Unmarshal(result.Value, &link)
+		if err != nil {
+			log.Printf("failed to json.Unmarshal value of key %s: %v", result.Key, err)
+			continue
+		}
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// putLink stores a link under its parent and under its child, so that both directions can be listed
+func putLink(ctx contractapi.TransactionContextInterface, link *RecordLink) error {
+	outKey, err := ctx.GetStub().CreateCompositeKey(linkOutPrefix, []string{link.Parent, link.Type, link.Child})
+	if err != nil {
+		return err
+	}
+	inKey, err := ctx.GetStub().CreateCompositeKey(linkInPrefix, []string{link.Child, link.Type, link.Parent})
+	if err != nil {
+		return err
+	}
+
+	var linkBytes []byte
+	linkBytes, _ = json.Marshal(link)
+	err = ctx.GetStub().PutState(outKey, linkBytes)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+	err = ctx.GetStub().PutState(inKey, linkBytes)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+
+	return nil
+}
+
+func deleteLink(ctx contractapi.TransactionContextInterface, link *RecordLink) error {
+	outKey, err := ctx.GetStub().CreateCompositeKey(linkOutPrefix, []string{link.Parent, link.Type, link.Child})
+	if err != nil {
+		return err
+	}
+	inKey, err := ctx.GetStub().CreateCompositeKey(linkInPrefix, []string{link.Child, link.Type, link.Parent})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().DelState(outKey)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+	err = ctx.GetStub().DelState(inKey)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+
+	return nil
+}
+
+func readLinkType(ctx contractapi.TransactionContextInterface, name string) (*LinkType, error) {
+	linkTypeKey, err := ctx.GetStub().CreateCompositeKey(linkTypePrefix, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	linkTypeBytes, err := ctx.GetStub().GetState(linkTypeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read link type %s: %v", name, err)
+	}
+	if linkTypeBytes == nil {
+		return nil, nil
+	}
+
+	var linkType LinkType
+	err = json.Unmarshal(linkTypeBytes, &linkType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkType, nil
+}
+
+// purgeTree returns key followed by the children a purge of key deletes with it, following links of
+// cascading types. It reports false if a live child is linked with a restricting type or is frozen.
+// purged holds the keys already purged in the transaction.
+func purgeTree(ctx contractapi.TransactionContextInterface, key string, purged map[string]bool) ([]string, bool, error) {
+	keys := []string{key}
+	planned := map[string]bool{key: true}
+	for i := 0; i < len(keys); i++ {
+		children, err := readLinks(ctx, linkOutPrefix, keys[i], "")
+		if err != nil {
+			return nil, false, err
+		}
+		for _, link := range children {
+			if purged[link.Child] || planned[link.Child] {
+				continue
+			}
+			child, err := readRecord(ctx, "", link.Child)
+			if err != nil {
+				return nil, false, err
+			}
+			if child == nil || child.Tombstone != nil {
+				continue
+			}
+			linkType, err := readLinkType(ctx, link.Type)
+			if err != nil {
+				return nil, false, err
+			}
+			if linkType == nil || linkType.OnDelete != LinkPolicyCascade {
+				return nil, false, nil
+			}
+			freeze, err := readFreeze(ctx, "", link.Child)
+			if err != nil {
+				return nil, false, err
+			}
+			if freeze != nil {
+				return nil, false, nil
+			}
+			planned[link.Child] = true
+			keys = append(keys, link.Child)
+		}
+	}
+
+	return keys, true, nil
+}
+
+// purgeLinkedRecord removes the record at key together with the links from and to it
+func purgeLinkedRecord(ctx contractapi.TransactionContextInterface, key string, recordData *RecordData) error {
+	for _, prefix := range []string{linkOutPrefix, linkInPrefix} {
+		links, err := readLinks(ctx, prefix, key, "")
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			err = deleteLink(ctx, &link)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return removeRecord(ctx, "", key, recordData)
+}
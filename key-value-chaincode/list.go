@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// RecordPage is one page of records together with the bookmark of the next page
+type RecordPage struct {
+	Records             []RecordData `json:"records"`
+	FetchedRecordsCount int32        `json:"fetchedRecordsCount"`
+	Bookmark            string       `json:"bookmark"`
+}
+
+// List returns the records with keys in [startKey, endKey), pageSize at a time.
+// Pass the bookmark of the previous page to continue, an empty bookmark starts at startKey.
+// Soft-deleted records are skipped, so a page may hold fewer than pageSize records.
+func (sc *KeyValueContract) List(ctx contractapi.TransactionContextInterface, startKey string, endKey string, pageSize int32, bookmark string) (*RecordPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+	err := checkAccessPolicy(ctx, "", AccessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list world state from %s to %s: %v", startKey, endKey, err)
+	}
+	defer iterator.Close()
+
+	records, err := collectRecords(ctx, iterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordPage{
+		Records:             records,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// ListByPrefix returns the records whose key starts with prefix, pageSize at a time
+func (sc *KeyValueContract) ListByPrefix(ctx contractapi.TransactionContextInterface, prefix string, pageSize int32, bookmark string) (*RecordPage, error) {
+	endKey := ""
+	if prefix != "" {
+		endKey = prefix + string(utf8.MaxRune)
+	}
+
+	return sc.List(ctx, prefix, endKey, pageSize, bookmark)
+}
+
+// collectRecords decodes the records of a query result, skipping tombstones and expired records.
+// The key of each record is set to the key it is stored at, the key inside a value is not checked on write.
+func collectRecords(ctx contractapi.TransactionContextInterface, iterator shim.StateQueryIteratorInterface) ([]RecordData, error) {
+	records := []RecordData{}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+
+		// a CouchDB selector also matches the settings, schemas, links and other documents at composite keys
+		key, isRecord, err := recordKey(ctx, result.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !isRecord {
+			continue
+		}
+
+		var recordData RecordData
+		err = json.Unmarshal(result.Value, &recordData)
+		if err != nil {
+			log.Printf("failed to json.Unmarshal value of key %s: %v", result.Key, err)
+			continue
+		}
+		live, err := isLive(ctx, &recordData)
+		if err != nil {
+			return nil, err
+		}
+		if !live {
+			continue
+		}
+		recordData.Key = key
+
+		records = append(records, recordData)
+	}
+
+	return records, nil
+}
+
+// recordKey returns the key of the record stored at stateKey, without the namespace of a namespaced record.
+// It reports false if stateKey does not hold a record.
+func recordKey(ctx contractapi.TransactionContextInterface, stateKey string) (string, bool, error) {
+	if !strings.HasPrefix(stateKey, "\x00") {
+		return stateKey, true, nil
+	}
+	objectType, attributes, err := ctx.GetStub().SplitCompositeKey(stateKey)
+	if err != nil {
+		return "", false, err
+	}
+	if objectType != recordPrefix || len(attributes) != 2 {
+		return "", false, nil
+	}
+
+	return attributes[1], true, nil
+}
@@ -0,0 +1,180 @@
+// Package merkle builds Merkle trees over canonical-JSON records and checks inclusion proofs
+// against their roots. Clients use it to compute the root anchored with the AnchorBatch
+// transaction of the key-value chaincode and the proofs checked by VerifyInclusion.
+//
+// Leaves are hashed as SHA-256(0x00 || canonical record) and inner nodes as
+// SHA-256(0x01 || left || right), so a leaf can never be passed off as an inner node.
+// A node without a sibling is carried up to the next level unchanged.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Positions of a sibling in a proof step
+const (
+	Left  = "left"
+	Right = "right"
+)
+
+// ProofStep is the sibling hash needed at one level of the tree
+type ProofStep struct {
+	Hash     string `json:"hash"`
+	Position string `json:"position"`
+}
+
+// Proof shows that the leaf at LeafIndex is part of a tree, the steps go from the leaf up to the root
+type Proof struct {
+	LeafIndex int         `json:"leafIndex"`
+	Steps     []ProofStep `json:"steps"`
+}
+
+// Tree is a Merkle tree over a list of records, levels[0] holds the leaf hashes
+type Tree struct {
+	levels [][][]byte
+}
+
+// Canonicalize returns the canonical JSON of a record: object keys sorted, no insignificant
+// whitespace, no HTML escaping and numbers kept as written.
+func Canonicalize(record []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(record))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, fmt.Errorf("record is not valid JSON: %v", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("record must be a single JSON value")
+	}
+
+	var canonical bytes.Buffer
+	encoder := json.NewEncoder(&canonical)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encode terminates the value with a newline
+	return bytes.TrimSuffix(canonical.Bytes(), []byte("\n")), nil
+}
+
+// LeafHash returns the hash of the canonical JSON of a record
+func LeafHash(record []byte) ([]byte, error) {
+	canonical, err := Canonicalize(record)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte{leafPrefix})
+	hash.Write(canonical)
+
+	return hash.Sum(nil), nil
+}
+
+func nodeHash(left []byte, right []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{nodePrefix})
+	hash.Write(left)
+	hash.Write(right)
+
+	return hash.Sum(nil)
+}
+
+// NewTree builds the tree over records, the order of records is the order of the leaves
+func NewTree(records [][]byte) (*Tree, error) {
+	if len(records) == 0 {
+		return nil, errors.New("a tree needs at least one record")
+	}
+
+	leaves := make([][]byte, len(records))
+	for i, record := range records {
+		leaf, err := LeafHash(record)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", i, err)
+		}
+		leaves[i] = leaf
+	}
+
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, nodeHash(level[i], level[i+1]))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return &Tree{levels: levels}, nil
+}
+
+// Root returns the root hash of the tree
+func (tree *Tree) Root() []byte {
+	return tree.levels[len(tree.levels)-1][0]
+}
+
+// RootHex returns the root hash of the tree hex encoded, as AnchorBatch expects it
+func (tree *Tree) RootHex() string {
+	return hex.EncodeToString(tree.Root())
+}
+
+// Proof returns the inclusion proof of the record at index
+func (tree *Tree) Proof(index int) (*Proof, error) {
+	if index < 0 || index >= len(tree.levels[0]) {
+		return nil, fmt.Errorf("leaf index %d is out of range", index)
+	}
+
+	proof := Proof{LeafIndex: index, Steps: []ProofStep{}}
+	for _, level := range tree.levels[:len(tree.levels)-1] {
+		if index%2 == 1 {
+			proof.Steps = append(proof.Steps, ProofStep{Hash: hex.EncodeToString(level[index-1]), Position: Left})
+		} else if index+1 < len(level) {
+			proof.Steps = append(proof.Steps, ProofStep{Hash: hex.EncodeToString(level[index+1]), Position: Right})
+		}
+		index /= 2
+	}
+
+	return &proof, nil
+}
+
+// Verify reports whether proof shows that record is part of the tree with the given root
+func Verify(record []byte, proof Proof, root []byte) (bool, error) {
+	hash, err := LeafHash(record)
+	if err != nil {
+		return false, err
+	}
+
+	for i, step := range proof.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return false, fmt.Errorf("proof step %d does not hold a hex SHA-256 hash", i)
+		}
+		switch step.Position {
+		case Left:
+			hash = nodeHash(sibling, hash)
+		case Right:
+			hash = nodeHash(hash, sibling)
+		default:
+			return false, fmt.Errorf("proof step %d has unknown position %s, must be %s or %s", i, step.Position, Left, Right)
+		}
+	}
+
+	return bytes.Equal(hash, root), nil
+}
@@ -0,0 +1,205 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func testRecords(count int) [][]byte {
+	records := make([][]byte, count)
+	for i := range records {
+		records[i] = []byte(fmt.Sprintf(`{"key":"k%d","name":"record %d","version":1}`, i, i))
+	}
+
+	return records
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+		want   string
+	}{
+		{"sorts keys", `{"b":1,"a":2}`, `{"a":2,"b":1}`},
+		{"sorts nested keys", `{"z":{"y":1,"x":[{"d":1,"c":2}]}}`, `{"z":{"x":[{"c":2,"d":1}],"y":1}}`},
+		{"drops whitespace", "{ \"a\" :\n [1, 2] }", `{"a":[1,2]}`},
+		{"keeps numbers as written", `{"a":1.50,"b":12345678901234567891,"c":1e3,"d":-0.0}`, `{"a":1.50,"b":12345678901234567891,"c":1e3,"d":-0.0}`},
+		{"does not escape HTML", `{"a":"<b>&</b>"}`, `{"a":"<b>&</b>"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canonical, err := Canonicalize([]byte(test.record))
+			if err != nil {
+				t.Fatalf("Canonicalize: %v", err)
+			}
+			if string(canonical) != test.want {
+				t.Errorf("Canonicalize(%s) = %s, want %s", test.record, canonical, test.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeRejectsInvalidJSON(t *testing.T) {
+	for _, record := range []string{``, `{"a":`, `{"a":1} {"b":2}`} {
+		_, err := Canonicalize([]byte(record))
+		if err == nil {
+			t.Errorf("Canonicalize(%q) succeeded, want an error", record)
+		}
+	}
+}
+
+func TestLeafHashIgnoresKeyOrder(t *testing.T) {
+	first, err := LeafHash([]byte(`{"key":"k1","version":1,"tokenData":{"a":1,"b":2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LeafHash([]byte(`{"tokenData":{"b":2,"a":1},"version":1,"key":"k1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("leaf hashes differ: %x and %x", first, second)
+	}
+}
+
+func TestRoot(t *testing.T) {
+	records := testRecords(3)
+	leaves := make([][]byte, len(records))
+	for i, record := range records {
+		canonical, err := Canonicalize(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf := sha256.Sum256(append([]byte{leafPrefix}, canonical...))
+		leaves[i] = leaf[:]
+	}
+	pair := sha256.Sum256(append(append([]byte{nodePrefix}, leaves[0]...), leaves[1]...))
+	// the third leaf has no sibling and is carried up unchanged
+	root := sha256.Sum256(append(append([]byte{nodePrefix}, pair[:]...), leaves[2]...))
+
+	tree, err := NewTree(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.RootHex() != hex.EncodeToString(root[:]) {
+		t.Errorf("RootHex() = %s, want %x", tree.RootHex(), root)
+	}
+}
+
+func TestProofRoundTrip(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 5} {
+		records := testRecords(count)
+		tree, err := NewTree(records)
+		if err != nil {
+			t.Fatalf("%d leaves: NewTree: %v", count, err)
+		}
+		for index, record := range records {
+			proof, err := tree.Proof(index)
+			if err != nil {
+				t.Fatalf("%d leaves: Proof(%d): %v", count, index, err)
+			}
+			ok, err := Verify(record, *proof, tree.Root())
+			if err != nil {
+				t.Fatalf("%d leaves: Verify leaf %d: %v", count, index, err)
+			}
+			if !ok {
+				t.Errorf("%d leaves: proof of leaf %d does not verify", count, index)
+			}
+			if count > 1 {
+				other := records[(index+1)%count]
+				ok, _ = Verify(other, *proof, tree.Root())
+				if ok {
+					t.Errorf("%d leaves: proof of leaf %d verifies another record", count, index)
+				}
+			}
+		}
+	}
+}
+
+func TestProofRejectsTampering(t *testing.T) {
+	records := testRecords(5)
+	tree, err := NewTree(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, record := range records {
+		proof, err := tree.Proof(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for step := range proof.Steps {
+			tampered := Proof{LeafIndex: proof.LeafIndex, Steps: append([]ProofStep{}, proof.Steps...)}
+			sibling, _ := hex.DecodeString(tampered.Steps[step].Hash)
+			sibling[0] ^= 0x01
+			tampered.Steps[step].Hash = hex.EncodeToString(sibling)
+			ok, err := Verify(record, tampered, tree.Root())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				t.Errorf("leaf %d verifies with a tampered sibling at step %d", index, step)
+			}
+
+			swapped := Proof{LeafIndex: proof.LeafIndex, Steps: append([]ProofStep{}, proof.Steps...)}
+			if swapped.Steps[step].Position == Left {
+				swapped.Steps[step].Position = Right
+			} else {
+				swapped.Steps[step].Position = Left
+			}
+			ok, err = Verify(record, swapped, tree.Root())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				t.Errorf("leaf %d verifies with a swapped position at step %d", index, step)
+			}
+		}
+	}
+}
+
+func TestVerifyRejectsMalformedSteps(t *testing.T) {
+	records := testRecords(2)
+	tree, err := NewTree(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := tree.Proof(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shortHash := Proof{Steps: []ProofStep{{Hash: "abcd", Position: Right}}}
+	_, err = Verify(records[0], shortHash, tree.Root())
+	if err == nil {
+		t.Error("Verify accepted a sibling that is not a SHA-256 hash")
+	}
+	unknownPosition := Proof{Steps: []ProofStep{{Hash: proof.Steps[0].Hash, Position: "up"}}}
+	_, err = Verify(records[0], unknownPosition, tree.Root())
+	if err == nil {
+		t.Error("Verify accepted an unknown position")
+	}
+}
+
+func TestProofOutOfRange(t *testing.T) {
+	tree, err := NewTree(testRecords(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []int{-1, 3} {
+		_, err = tree.Proof(index)
+		if err == nil {
+			t.Errorf("Proof(%d) succeeded, want an error", index)
+		}
+	}
+}
+
+func TestNewTreeRejectsEmpty(t *testing.T) {
+	_, err := NewTree(nil)
+	if err == nil {
+		t.Error("NewTree(nil) succeeded, want an error")
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define objectType names for prefix
+const (
+	recordPrefix    = "record"
+	namespacePrefix = "namespace"
+)
+
+// NamespaceSettings apply to every record in a namespace.
+// A MaxTokenDataLen of 0 keeps the maxAttributes of the config, empty WriterMSPs lets every MSP write.
+// With an Approval policy updates become change proposals, see ApproveChange.
+// An Access policy requires certificate attributes of the clients reading or writing the namespace.
+type NamespaceSettings struct {
+	Namespace       string          `json:"namespace"`
+	MaxTokenDataLen int             `json:"maxTokenDataLen,omitempty" metadata:",optional"`
+	WriterMSPs      []string        `json:"writerMSPs"`
+	Schema          string          `json:"schema,omitempty" metadata:",optional"`
+	Approval        *ApprovalPolicy `json:"approval,omitempty" metadata:",optional"`
+	Access          *AccessPolicy   `json:"access,omitempty" metadata:",optional"`
+	TxID            string          `json:"txId"`
+}
+
+// SetNamespaceSettings creates a namespace or replaces its settings.
+// settingsJSON is an object with the optional fields maxTokenDataLen, writerMSPs, schema, approval and access.
+func (sc *KeyValueContract) SetNamespaceSettings(ctx contractapi.TransactionContextInterface, namespace string, settingsJSON string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if namespace == "" {
+		return errors.New("namespace must not be empty")
+	}
+
+	var settings NamespaceSettings
+	err = json.Unmarshal([]byte(settingsJSON), &settings)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal([]byte(settingsJSON), &settings) in SetNamespaceSettings: %v", err)
+		return fmt.Errorf("settings of namespace %s are not valid JSON: %v", namespace, err)
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if settings.MaxTokenDataLen < 0 {
+		return errors.New("maxTokenDataLen must not be negative")
+	}
+	if config.MaxAttributes > 0 && settings.MaxTokenDataLen > config.MaxAttributes {
+		return fmt.Errorf("maxTokenDataLen must not exceed %d, the maxAttributes of the config", config.MaxAttributes)
+	}
+	if settings.WriterMSPs == nil {
+		settings.WriterMSPs = []string{}
+	}
+	if settings.Schema != "" {
+		err = checkSchema(settings.Schema, "namespace "+namespace)
+		if err != nil {
+			return err
+		}
+	}
+	if settings.Approval != nil {
+		err = checkApprovalPolicy(settings.Approval)
+		if err != nil {
+			return err
+		}
+	}
+	if settings.Access != nil {
+		err = validateAccessPolicy(settings.Access)
+		if err != nil {
+			return err
+		}
+	}
+	settings.Namespace = namespace
+	settings.TxID = ctx.GetStub().GetTxID()
+
+	settingsKey, err := ctx.GetStub().CreateCompositeKey(namespacePrefix, []string{namespace})
+	if err != nil {
+		return err
+	}
+	var settingsBytes []byte
+	settingsBytes, _ = json.Marshal(settings)
+
+	return ctx.GetStub().PutState(settingsKey, settingsBytes)
+}
+
+// GetNamespaceSettings returns the settings of a namespace
+func (sc *KeyValueContract) GetNamespaceSettings(ctx contractapi.TransactionContextInterface, namespace string) (*NamespaceSettings, error) {
+	settings, err := readNamespaceSettings(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, fmt.Errorf("namespace %s does not exist", namespace)
+	}
+
+	return settings, nil
+}
+
+// ListNamespaces returns the names of all namespaces
+func (sc *KeyValueContract) ListNamespaces(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(namespacePrefix, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list namespaces: %v", err)
+	}
+	defer iterator.Close()
+
+	namespaces := []string{}
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("unable to interact with world state: %v", err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(result.Key)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, attributes[0])
+	}
+
+	return namespaces, nil
+}
+
+// ListInNamespace returns the records of a namespace ordered by key, pageSize at a time
+func (sc *KeyValueContract) ListInNamespace(ctx contractapi.TransactionContextInterface, namespace string, pageSize int32, bookmark string) (*RecordPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+	if namespace == "" {
+		return nil, errors.New("namespace must not be empty")
+	}
+	err := checkAccessPolicy(ctx, namespace, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(recordPrefix, []string{namespace}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list namespace %s: %v", namespace, err)
+	}
+	defer iterator.Close()
+
+	records, err := collectRecords(ctx, iterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordPage{
+		Records:             records,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// CreateInNamespace adds a new record with key to a namespace
+func (sc *KeyValueContract) CreateInNamespace(ctx contractapi.TransactionContextInterface, namespace string, key string, value string) (string, error) {
+	err := requireNamespace(namespace)
+	if err != nil {
+		return key, err
+	}
+	event, err := createData(ctx, namespace, key, value)
+	if err != nil {
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+// ReadInNamespace returns the record with key in a namespace
+func (sc *KeyValueContract) ReadInNamespace(ctx contractapi.TransactionContextInterface, namespace string, key string) (*RecordData, error) {
+	err := requireNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAccessPolicy(ctx, namespace, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	recordData, err := readRecord(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	live, err := isLive(ctx, recordData)
+	if err != nil {
+		return nil, err
+	}
+	if !live {
+		return nil, fmt.Errorf("cannot read world state pair with key %s in namespace %s. Does not exist", key, namespace)
+	}
+
+	return recordData, nil
+}
+
+// UpdateInNamespace changes the record with key in a namespace.
+// In a namespace with an approval policy it creates a change proposal instead, its id is the tx id.
+func (sc *KeyValueContract) UpdateInNamespace(ctx contractapi.TransactionContextInterface, namespace string, key string, value string) (string, error) {
+	err := requireNamespace(namespace)
+	if err != nil {
+		return key, err
+	}
+	settings, err := readNamespaceSettings(ctx, namespace)
+	if err != nil {
+		return key, err
+	}
+	if settings != nil && settings.Approval != nil {
+		return key, proposeChange(ctx, settings, key, value)
+	}
+	event, err := updateData(ctx, namespace, key, value)
+	if err != nil {
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+// DeleteInNamespace removes the record with key from a namespace
+func (sc *KeyValueContract) DeleteInNamespace(ctx contractapi.TransactionContextInterface, namespace string, key string) (string, error) {
+	err := requireNamespace(namespace)
+	if err != nil {
+		return key, err
+	}
+	event, err := deleteData(ctx, namespace, key)
+	if err != nil {
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+func readNamespaceSettings(ctx contractapi.TransactionContextInterface, namespace string) (*NamespaceSettings, error) {
+	settingsKey, err := ctx.GetStub().CreateCompositeKey(namespacePrefix, []string{namespace})
+	if err != nil {
+		return nil, err
+	}
+	settingsBytes, err := ctx.GetStub().GetState(settingsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings of namespace %s: %v", namespace, err)
+	}
+	if settingsBytes == nil {
+		return nil, nil
+	}
+
+	var settings NamespaceSettings
+	err = json.Unmarshal(settingsBytes, &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// checkNamespaceWriter returns the settings of namespace if the client's MSP may write to it.
+// The records outside any namespace have no settings and nil is returned for them.
+func checkNamespaceWriter(ctx contractapi.TransactionContextInterface, namespace string) (*NamespaceSettings, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+
+	settings, err := readNamespaceSettings(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, fmt.Errorf("namespace %s does not exist", namespace)
+	}
+	if len(settings.WriterMSPs) == 0 {
+		return settings, nil
+	}
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MSPID: %v", err)
+	}
+	if !containsPrincipal(settings.WriterMSPs, clientMSPID) {
+		return nil, fmt.Errorf("MSP %s is not allowed to write to namespace %s", clientMSPID, namespace)
+	}
+
+	return settings, nil
+}
+
+// validateNamespaceRecord checks a record against the TokenData limit and schema of its namespace
+func validateNamespaceRecord(settings *NamespaceSettings, recordData *RecordData) error {
+	if settings.MaxTokenDataLen > 0 && len(recordData.TokenData) > settings.MaxTokenDataLen {
+		return fmt.Errorf("len(recordData.TokenData) > %d, the limit of namespace %s", settings.MaxTokenDataLen, settings.Namespace)
+	}
+	if settings.Schema != "" {
+		return validateTokenData(settings.Schema, recordData.TokenData, "namespace "+settings.Namespace)
+	}
+
+	return nil
+}
+
+// recordStateKey returns the world state key of a record, records outside any namespace keep their plain key
+func recordStateKey(ctx contractapi.TransactionContextInterface, namespace string, key string) (string, error) {
+	if namespace == "" {
+		// plain keys must not reach into the composite keys of namespaces, settings and indexes
+		if strings.HasPrefix(key, "\x00") {
+			return "", fmt.Errorf("key %q must not start with a null character", key)
+		}
+		return key, nil
+	}
+
+	return ctx.GetStub().CreateCompositeKey(recordPrefix, []string{namespace, key})
+}
+
+func requireNamespace(namespace string) error {
+	if namespace == "" {
+		return errors.New("namespace must not be empty")
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Patch formats accepted by Patch
+const (
+	// PatchFormatMerge is an RFC 7386 JSON Merge Patch
+	PatchFormatMerge = "merge"
+	// PatchFormatJSONPatch is an RFC 6902 JSON Patch
+	PatchFormatJSONPatch = "json-patch"
+)
+
+// Patch applies a partial update to the record at key, format is merge or json-patch.
+// The patched record goes through the same checks as Update. It drops the device signature of the record,
+// unless the patch adds a signature of the patched record.
+func (sc *KeyValueContract) Patch(ctx contractapi.TransactionContextInterface, key string, patchJSON string, format string) (string, error) {
+	existing, err := readRecord(ctx, "", key)
+	if err != nil {
+		return key, err
+	}
+	live, err := isLive(ctx, existing)
+	if err != nil {
+		return key, err
+	}
+	if !live {
+		return key, fmt.Errorf("cannot patch world state pair with key %s. Does not exist", key)
+	}
+	err = checkWriteAccess(ctx, key, existing)
+	if err != nil {
+		return key, err
+	}
+	// an encrypted record only holds ciphertext, there is nothing to patch
+	if existing.Encrypted != nil {
+		return key, fmt.Errorf("cannot patch world state pair with key %s. It is encrypted", key)
+	}
+
+	// the device signature covers the stored version only, the patched record is no longer signed
+	unsigned := *existing
+	unsigned.Signature = nil
+	var existingBytes []byte
+	existingBytes, _ = json.Marshal(unsigned)
+	patched, err := applyPatch(existingBytes, patchJSON, format)
+	if err != nil {
+		log.Printf("failed to apply %s patch to key %s: %v", format, key, err)
+		return key, err
+	}
+
+	event, err := putData(ctx, "", key, string(patched), existing)
+	if err != nil {
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+func applyPatch(document []byte, patchJSON string, format string) ([]byte, error) {
+	switch format {
+	case PatchFormatMerge:
+		patched, err := jsonpatch.MergePatch(document, []byte(patchJSON))
+		if err != nil {
+			return nil, fmt.Errorf("invalid merge patch: %v", err)
+		}
+		return patched, nil
+	case PatchFormatJSONPatch:
+		patch, err := jsonpatch.DecodePatch([]byte(patchJSON))
+		if err != nil {
+			return nil, fmt.Errorf("invalid json patch: %v", err)
+		}
+		patched, err := patch.Apply(document)
+		if err != nil {
+			return nil, fmt.Errorf("json patch cannot be applied: %v", err)
+		}
+		return patched, nil
+	default:
+		return nil, fmt.Errorf("unknown patch format %s, must be %s or %s", format, PatchFormatMerge, PatchFormatJSONPatch)
+	}
+}
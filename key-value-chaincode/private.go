@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define objectType names for prefix
+const privateHashPrefix = "privateHash"
+
+// Define key names of the transient map
+const (
+	transientRecordKey = "record"
+	transientSaltKey   = "salt"
+)
+
+// minSaltLen keeps the public hash from being brute forced for small records
+const minSaltLen = 16
+
+// orgCollectionSuffix ends the names of the collections of a single org, e.g. Org1MSPPrivateCollection.
+// They are endorsed by their org alone, so a record in them gets no public hash: any public write would
+// fall under the chaincode endorsement policy and send the record to the peers of the other orgs.
+const orgCollectionSuffix = "MSPPrivateCollection"
+
+// PrivateRecordHash is kept on the public world state for every record in a shared private data collection.
+// For a record in the collection of a single org only Hash is known outside the org, it is the hash of
+// the record that Fabric keeps on the ledger.
+type PrivateRecordHash struct {
+	Collection string `json:"collection"`
+	Key        string `json:"key"`
+	Hash       string `json:"hash"`
+	Version    int    `json:"version"`
+	TxID       string `json:"txId"`
+	Owner      string `json:"owner,omitempty" metadata:",optional"`
+	OwnerMSP   string `json:"ownerMSP,omitempty" metadata:",optional"`
+}
+
+// CreatePrivate adds the record passed in the transient map under "record" to a private data collection.
+// The public world state only gets the hex SHA-256 of the salt from the transient map followed by the stored record,
+// the collection of a single org takes no salt and leaves the public world state alone.
+func (sc *KeyValueContract) CreatePrivate(ctx contractapi.TransactionContextInterface, collection string, key string) (string, error) {
+	existing, err := readPrivateRecord(ctx, collection, key)
+	if err != nil {
+		return key, err
+	}
+	if existing != nil {
+		return key, fmt.Errorf("cannot create private data with key %s in collection %s. Already exists", key, collection)
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return key, err
+	}
+	err = checkKeyPattern(config, key)
+	if err != nil {
+		return key, err
+	}
+
+	return putPrivateData(ctx, collection, key, nil)
+}
+
+// UpdatePrivate replaces a record in a private data collection with the record passed in the transient map
+func (sc *KeyValueContract) UpdatePrivate(ctx contractapi.TransactionContextInterface, collection string, key string) (string, error) {
+	existing, err := readPrivateRecord(ctx, collection, key)
+	if err != nil {
+		return key, err
+	}
+	if existing == nil {
+		return key, fmt.Errorf("cannot update private data with key %s in collection %s. Does not exist", key, collection)
+	}
+	err = checkWriteAccess(ctx, key, existing.record())
+	if err != nil {
+		return key, err
+	}
+
+	// version and owner are taken from the public hash so that peers outside the collection can endorse
+	return putPrivateData(ctx, collection, key, existing.record())
+}
+
+// ReadPrivate returns the record at key in a private data collection, the peer must be a member of the collection
+func (sc *KeyValueContract) ReadPrivate(ctx contractapi.TransactionContextInterface, collection string, key string) (string, error) {
+	err := checkAccessPolicy(ctx, "", AccessRead)
+	if err != nil {
+		return "", err
+	}
+	existing, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return "", fmt.Errorf("unable to read private data with key %s in collection %s: %v", key, collection, err)
+	}
+	if existing == nil {
+		return "", fmt.Errorf("cannot read private data with key %s in collection %s. Does not exist", key, collection)
+	}
+
+	return string(existing), nil
+}
+
+// ReadPrivateHash returns the public salted hash of a private record, readable by every org
+func (sc *KeyValueContract) ReadPrivateHash(ctx contractapi.TransactionContextInterface, collection string, key string) (*PrivateRecordHash, error) {
+	var existing *PrivateRecordHash
+	var err error
+	if isOrgCollection(collection) {
+		existing, err = readLedgerHash(ctx, collection, key)
+	} else {
+		existing, err = readPrivateHash(ctx, collection, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("cannot read private data hash with key %s in collection %s. Does not exist", key, collection)
+	}
+
+	return existing, nil
+}
+
+// DeletePrivate removes a record from a private data collection together with its public hash
+func (sc *KeyValueContract) DeletePrivate(ctx contractapi.TransactionContextInterface, collection string, key string) (string, error) {
+	existing, err := readPrivateRecord(ctx, collection, key)
+	if err != nil {
+		return key, err
+	}
+	if existing == nil {
+		return key, fmt.Errorf("cannot delete private data with key %s in collection %s. Does not exist", key, collection)
+	}
+	err = checkAccessPolicy(ctx, "", AccessWrite)
+	if err != nil {
+		return key, err
+	}
+	err = checkWriteAccess(ctx, key, existing.record())
+	if err != nil {
+		return key, err
+	}
+
+	err = ctx.GetStub().DelPrivateData(collection, key)
+	if err != nil {
+		return key, fmt.Errorf("unable to delete private data with key %s in collection %s: %v", key, collection, err)
+	}
+	if isOrgCollection(collection) {
+		return key, nil
+	}
+	hashKey, err := ctx.GetStub().CreateCompositeKey(privateHashPrefix, []string{collection, key})
+	if err != nil {
+		return key, err
+	}
+	err = ctx.GetStub().DelState(hashKey)
+	if err != nil {
+		return key, errors.New("unable to interact with world state")
+	}
+
+	return key, nil
+}
+
+// putPrivateData validates the transient record, writes it to the collection and its salted hash to the world state
+func putPrivateData(ctx contractapi.TransactionContextInterface, collection string, key string, previous *RecordData) (string, error) {
+	if collection == "" {
+		return key, errors.New("collection must not be empty")
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return key, fmt.Errorf("failed to get transient map: %v", err)
+	}
+	value, ok := transientMap[transientRecordKey]
+	if !ok {
+		return key, fmt.Errorf("the record must be passed in the transient map under %s", transientRecordKey)
+	}
+	salt := transientMap[transientSaltKey]
+	if !isOrgCollection(collection) && len(salt) < minSaltLen {
+		return key, fmt.Errorf("a salt of at least %d bytes must be passed in the transient map under %s", minSaltLen, transientSaltKey)
+	}
+
+	recordData, err := buildRecord(ctx, "", key, string(value), previous)
+	if err != nil {
+		return key, err
+	}
+	var recordDataBytes []byte
+	recordDataBytes, _ = json.Marshal(recordData)
+	err = ctx.GetStub().PutPrivateData(collection, key, recordDataBytes)
+	if err != nil {
+		log.Printf("failed to PutPrivateData in collection %s: %v", collection, err)
+		return key, fmt.Errorf("unable to write private data with key %s in collection %s: %v", key, collection, err)
+	}
+	if isOrgCollection(collection) {
+		return key, nil
+	}
+
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write(recordDataBytes)
+	privateRecordHash := PrivateRecordHash{
+		Collection: collection,
+		Key:        key,
+		Hash:       hex.EncodeToString(hash.Sum(nil)),
+		Version:    recordData.Version,
+		TxID:       recordData.TxID,
+		Owner:      recordData.Owner,
+		OwnerMSP:   recordData.OwnerMSP,
+	}
+	hashKey, err := ctx.GetStub().CreateCompositeKey(privateHashPrefix, []string{collection, key})
+	if err != nil {
+		return key, err
+	}
+	var privateRecordHashBytes []byte
+	privateRecordHashBytes, _ = json.Marshal(privateRecordHash)
+	err = ctx.GetStub().PutState(hashKey, privateRecordHashBytes)
+	if err != nil {
+		return key, errors.New("unable to interact with world state")
+	}
+
+	return key, nil
+}
+
+// record returns the public part of a private record that access checks and versioning need
+func (privateRecordHash *PrivateRecordHash) record() *RecordData {
+	return &RecordData{
+		Key:      privateRecordHash.Key,
+		Version:  privateRecordHash.Version,
+		Owner:    privateRecordHash.Owner,
+		OwnerMSP: privateRecordHash.OwnerMSP,
+	}
+}
+
+func readPrivateHash(ctx contractapi.TransactionContextInterface, collection string, key string) (*PrivateRecordHash, error) {
+	hashKey, err := ctx.GetStub().CreateCompositeKey(privateHashPrefix, []string{collection, key})
+	if err != nil {
+		return nil, err
+	}
+	privateRecordHashBytes, err := ctx.GetStub().GetState(hashKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to interact with world state,key %s", key)
+	}
+	if privateRecordHashBytes == nil {
+		return nil, nil
+	}
+
+	var privateRecordHash PrivateRecordHash
+	err = json.Unmarshal(privateRecordHashBytes, &privateRecordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &privateRecordHash, nil
+}
+
+// readPrivateRecord returns the public part of a private record, read from the collection itself for
+// the collection of a single org. Its endorsement policy makes sure only member peers read it.
+func readPrivateRecord(ctx contractapi.TransactionContextInterface, collection string, key string) (*PrivateRecordHash, error) {
+	if !isOrgCollection(collection) {
+		return readPrivateHash(ctx, collection, key)
+	}
+
+	recordDataBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private data with key %s in collection %s: %v", key, collection, err)
+	}
+	if recordDataBytes == nil {
+		return nil, nil
+	}
+	var recordData RecordData
+	err = json.Unmarshal(recordDataBytes, &recordData)
+	if err != nil {
+		return nil, err
+	}
+	// the ledger keeps the SHA-256 of the stored record
+	hash := sha256.Sum256(recordDataBytes)
+
+	return &PrivateRecordHash{
+		Collection: collection,
+		Key:        key,
+		Hash:       hex.EncodeToString(hash[:]),
+		Version:    recordData.Version,
+		TxID:       recordData.TxID,
+		Owner:      recordData.Owner,
+		OwnerMSP:   recordData.OwnerMSP,
+	}, nil
+}
+
+// readLedgerHash returns the hash Fabric keeps on the ledger for a private record, readable by every org
+func readLedgerHash(ctx contractapi.TransactionContextInterface, collection string, key string) (*PrivateRecordHash, error) {
+	hash, err := ctx.GetStub().GetPrivateDataHash(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private data hash with key %s in collection %s: %v", key, collection, err)
+	}
+	if hash == nil {
+		return nil, nil
+	}
+
+	return &PrivateRecordHash{
+		Collection: collection,
+		Key:        key,
+		Hash:       hex.EncodeToString(hash),
+	}, nil
+}
+
+func isOrgCollection(collection string) bool {
+	return strings.HasSuffix(collection, orgCollectionSuffix)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// queryFields are the RecordData fields a selector may reference, any path below tokenData is allowed
+var queryFields = map[string]bool{
+	"key":         true,
+	"name":        true,
+	"type":        true,
+	"description": true,
+	"version":     true,
+	"owner":       true,
+	"ownerMSP":    true,
+	"expiresAt":   true,
+	"tokenData":   true,
+}
+
+// queryOperators are the CouchDB selector operators a selector may use
+var queryOperators = map[string]bool{
+	"$and":       true,
+	"$or":        true,
+	"$nor":       true,
+	"$not":       true,
+	"$eq":        true,
+	"$ne":        true,
+	"$lt":        true,
+	"$lte":       true,
+	"$gt":        true,
+	"$gte":       true,
+	"$exists":    true,
+	"$type":      true,
+	"$in":        true,
+	"$nin":       true,
+	"$size":      true,
+	"$all":       true,
+	"$elemMatch": true,
+	"$allMatch":  true,
+}
+
+// Query runs a CouchDB selector over the records, pageSize at a time.
+// selectorJSON is only the selector object, e.g. {"tokenData.color":{"$eq":"red"}}.
+// Only fields in queryFields and operators in queryOperators are accepted.
+// Records the access policy of their namespace does not let the client read are left out of the page.
+func (sc *KeyValueContract) Query(ctx contractapi.TransactionContextInterface, selectorJSON string, pageSize int32, bookmark string) (*RecordPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("pageSize must be a positive integer")
+	}
+
+	var selector map[string]interface{}
+	err := json.Unmarshal([]byte(selectorJSON), &selector)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal([]byte(selectorJSON), &selector) in Query: %v", err)
+		return nil, fmt.Errorf("selector is not a JSON object: %v", err)
+	}
+	err = validateSelector(selector, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// soft-deleted records never match
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"$and": []interface{}{
+				selector,
+				map[string]interface{}{"tombstone": map[string]interface{}{"$exists": false}},
+			},
+		},
+	}
+	var queryBytes []byte
+	queryBytes, _ = json.Marshal(query)
+
+	iterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryBytes), pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query world state: %v", err)
+	}
+	defer iterator.Close()
+
+	records, err := collectRecords(ctx, iterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordPage{
+		Records:             filterReadable(ctx, records),
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// validateSelector walks a selector and rejects unknown operators and fields.
+// inField is true below a field name, where nested names are sub-fields of that field.
+func validateSelector(selector interface{}, inField bool) error {
+	switch value := selector.(type) {
+	case map[string]interface{}:
+		for name, child := range value {
+			if strings.HasPrefix(name, "$") {
+				if !queryOperators[name] {
+					return fmt.Errorf("selector operator %s is not allowed", name)
+				}
+				err := validateSelector(child, inField)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if !inField {
+				root := strings.SplitN(name, ".", 2)[0]
+				if !queryFields[root] {
+					return fmt.Errorf("selector field %s is not allowed", name)
+				}
+			}
+			err := validateSelector(child, true)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			err := validateSelector(child, inField)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Define objectType names for prefix
+const schemaPrefix = "schema"
+
+// RecordSchema is a JSON Schema registered for the TokenData of one record type
+type RecordSchema struct {
+	Type         string `json:"type"`
+	Schema       string `json:"schema"`
+	RegisteredBy string `json:"registeredBy"`
+	TxID         string `json:"txId"`
+}
+
+// RegisterSchema registers or replaces the JSON Schema that TokenData of records with the given type must match
+func (sc *KeyValueContract) RegisterSchema(ctx contractapi.TransactionContextInterface, recordType string, schemaJSON string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if recordType == "" {
+		return errors.New("record type must not be empty")
+	}
+
+	err = checkSchema(schemaJSON, "type "+recordType)
+	if err != nil {
+		return err
+	}
+
+	registeredBy, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client id: %v", err)
+	}
+
+	recordSchema := RecordSchema{
+		Type:         recordType,
+		Schema:       schemaJSON,
+		RegisteredBy: registeredBy,
+		TxID:         ctx.GetStub().GetTxID(),
+	}
+	schemaKey, err := ctx.GetStub().CreateCompositeKey(schemaPrefix, []string{recordType})
+	if err != nil {
+		return err
+	}
+	var recordSchemaBytes []byte
+	recordSchemaBytes, _ = json.Marshal(recordSchema)
+
+	return ctx.GetStub().PutState(schemaKey, recordSchemaBytes)
+}
+
+// ReadSchema returns the JSON Schema registered for a record type
+func (sc *KeyValueContract) ReadSchema(ctx contractapi.TransactionContextInterface, recordType string) (*RecordSchema, error) {
+	recordSchema, err := readSchema(ctx, recordType)
+	if err != nil {
+		return nil, err
+	}
+	if recordSchema == nil {
+		return nil, fmt.Errorf("no schema registered for record type %s", recordType)
+	}
+
+	return recordSchema, nil
+}
+
+func readSchema(ctx contractapi.TransactionContextInterface, recordType string) (*RecordSchema, error) {
+	schemaKey, err := ctx.GetStub().CreateCompositeKey(schemaPrefix, []string{recordType})
+	if err != nil {
+		return nil, err
+	}
+	recordSchemaBytes, err := ctx.GetStub().GetState(schemaKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema of type %s: %v", recordType, err)
+	}
+	if recordSchemaBytes == nil {
+		return nil, nil
+	}
+
+	var recordSchema RecordSchema
+	err = json.Unmarshal(recordSchemaBytes, &recordSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordSchema, nil
+}
+
+// validateRecordType checks the TokenData of a typed record against the schema registered for its type
+func validateRecordType(ctx contractapi.TransactionContextInterface, recordData *RecordData) error {
+	if recordData.Type == "" {
+		return nil
+	}
+
+	recordSchema, err := readSchema(ctx, recordData.Type)
+	if err != nil {
+		return err
+	}
+	if recordSchema == nil {
+		return fmt.Errorf("unknown record type %s, no schema registered", recordData.Type)
+	}
+
+	return validateTokenData(recordSchema.Schema, recordData.TokenData, "type "+recordData.Type)
+}
+
+// checkSchema makes sure schemaJSON is a JSON Schema the chaincode can use, subject names it in errors
+func checkSchema(schemaJSON string, subject string) error {
+	var schemaDoc interface{}
+	err := json.Unmarshal([]byte(schemaJSON), &schemaDoc)
+	if err != nil {
+		return fmt.Errorf("schema of %s is not valid JSON: %v", subject, err)
+	}
+	// the peer must never fetch anything while validating, only local references are allowed
+	err = checkLocalRefs(schemaDoc)
+	if err != nil {
+		return err
+	}
+	_, err = gojsonschema.NewSchema(gojsonschema.NewStringLoader(schemaJSON))
+	if err != nil {
+		return fmt.Errorf("schema of %s is not a valid JSON Schema: %v", subject, err)
+	}
+
+	return nil
+}
+
+// validateTokenData checks tokenData against a JSON Schema, subject names the schema in errors
+func validateTokenData(schemaJSON string, tokenData map[string]interface{}, subject string) error {
+	if tokenData == nil {
+		tokenData = map[string]interface{}{}
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewStringLoader(schemaJSON), gojsonschema.NewGoLoader(tokenData))
+	if err != nil {
+		log.Printf("failed to validate tokenData against schema of %s: %v", subject, err)
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	var problems []string
+	for _, resultError := range result.Errors() {
+		problems = append(problems, fmt.Sprintf("%s: %s", tokenDataPath(resultError), resultError.Description()))
+	}
+
+	return fmt.Errorf("tokenData does not match schema of %s: %s", subject, strings.Join(problems, "; "))
+}
+
+// tokenDataPath names the field a schema error refers to, e.g. tokenData.size.width
+func tokenDataPath(resultError gojsonschema.ResultError) string {
+	path := "tokenData"
+	if field := resultError.Field(); field != gojsonschema.STRING_CONTEXT_ROOT {
+		path += "." + field
+	}
+	// required errors are reported on the parent object
+	if property, ok := resultError.Details()["property"].(string); ok && resultError.Type() == "required" {
+		path += "." + property
+	}
+
+	return path
+}
+
+// checkLocalRefs rejects any $ref that points outside the schema document
+func checkLocalRefs(schemaDoc interface{}) error {
+	switch value := schemaDoc.(type) {
+	case map[string]interface{}:
+		for name, child := range value {
+			if ref, ok := child.(string); ok && name == "$ref" && !strings.HasPrefix(ref, "#") {
+				return fmt.Errorf("schema reference %s is not allowed, only local references are", ref)
+			}
+			err := checkLocalRefs(child)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			err := checkLocalRefs(child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/smallverse/hyperledger-fabric-v2-kubernetes-dev/key-value-chaincode/merkle"
+)
+
+// Define objectType names for prefix
+const devicePrefix = "device"
+
+// Signature algorithms of devices
+const (
+	// SignatureECDSA signs the SHA-256 of the signed bytes, the signature is ASN.1 DER encoded
+	SignatureECDSA = "ECDSA"
+	// SignatureEd25519 signs the signed bytes themselves
+	SignatureEd25519 = "Ed25519"
+)
+
+// Device is a signer that is not a Fabric identity, e.g. an IoT gateway, known by the id of its public key
+type Device struct {
+	KeyID           string `json:"keyId"`
+	Algorithm       string `json:"algorithm"`
+	PublicKey       string `json:"publicKey"`
+	Revoked         bool   `json:"revoked"`
+	RegisteredBy    string `json:"registeredBy"`
+	RegisteredByMSP string `json:"registeredByMSP"`
+	TxID            string `json:"txId"`
+}
+
+// RecordSignature is a detached signature of a record by a registered device.
+// Value is the base64 signature, Algorithm is filled in from the device.
+type RecordSignature struct {
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm,omitempty" metadata:",optional"`
+	Value     string `json:"value"`
+}
+
+// RegisterDevice registers the PEM encoded ECDSA or Ed25519 public key of a device under keyID
+func (sc *KeyValueContract) RegisterDevice(ctx contractapi.TransactionContextInterface, keyID string, publicKeyPEM string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if keyID == "" {
+		return errors.New("key id must not be empty")
+	}
+	existing, err := readDevice(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("device %s is already registered", keyID)
+	}
+	publicKey, err := parseDeviceKey(publicKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	registeredBy, registeredByMSP, err := clientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	device := Device{
+		KeyID:           keyID,
+		PublicKey:       publicKeyPEM,
+		RegisteredBy:    registeredBy,
+		RegisteredByMSP: registeredByMSP,
+		TxID:            ctx.GetStub().GetTxID(),
+	}
+	switch publicKey.(type) {
+	case *ecdsa.PublicKey:
+		device.Algorithm = SignatureECDSA
+	case ed25519.PublicKey:
+		device.Algorithm = SignatureEd25519
+	}
+
+	return putDevice(ctx, &device)
+}
+
+// RevokeDevice stops accepting signatures of a device, records it signed before keep their signature
+func (sc *KeyValueContract) RevokeDevice(ctx contractapi.TransactionContextInterface, keyID string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	device, err := sc.ReadDevice(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	device.Revoked = true
+	device.TxID = ctx.GetStub().GetTxID()
+
+	return putDevice(ctx, device)
+}
+
+// ReadDevice returns a registered device
+func (sc *KeyValueContract) ReadDevice(ctx contractapi.TransactionContextInterface, keyID string) (*Device, error) {
+	device, err := readDevice(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("device %s is not registered", keyID)
+	}
+
+	return device, nil
+}
+
+// verifyRecordSignature checks the signature of a record, if it has one, against value as the client sent it.
+// The value must hold key and the version of recordData, the version the write creates.
+func verifyRecordSignature(ctx contractapi.TransactionContextInterface, key string, value string, recordData *RecordData) error {
+	signature := recordData.Signature
+	if signature == nil {
+		return nil
+	}
+	if recordData.Key != key {
+		return fmt.Errorf("signed record is for key %s, not %s", recordData.Key, key)
+	}
+	device, err := readDevice(ctx, signature.KeyID)
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return fmt.Errorf("device %s is not registered", signature.KeyID)
+	}
+	if device.Revoked {
+		return fmt.Errorf("device %s is revoked", signature.KeyID)
+	}
+	publicKey, err := parseDeviceKey(device.PublicKey)
+	if err != nil {
+		return err
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature.Value)
+	if err != nil {
+		return fmt.Errorf("signature of device %s is not base64 encoded", signature.KeyID)
+	}
+	signedBytes, version, err := recordSignedBytes(value)
+	if err != nil {
+		return err
+	}
+	if version != strconv.Itoa(recordData.Version) {
+		return fmt.Errorf("signed record must have version %d, the version it creates", recordData.Version)
+	}
+
+	valid := false
+	switch publicKey := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signedBytes)
+		valid = ecdsa.VerifyASN1(publicKey, digest[:], signatureBytes)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(publicKey, signedBytes, signatureBytes)
+	}
+	if !valid {
+		return fmt.Errorf("signature of device %s does not match the record", signature.KeyID)
+	}
+	signature.Algorithm = device.Algorithm
+
+	return nil
+}
+
+// recordSignedBytes returns the bytes a device signs, the canonical JSON of value without its "signature",
+// see merkle.Canonicalize, and the version in value. Numbers are kept as written.
+func recordSignedBytes(value string) ([]byte, string, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var signed map[string]interface{}
+	err := decoder.Decode(&signed)
+	if err != nil {
+		return nil, "", err
+	}
+	delete(signed, "signature")
+	version, _ := signed["version"].(json.Number)
+
+	var signedBytes []byte
+	signedBytes, _ = json.Marshal(signed)
+	canonical, err := merkle.Canonicalize(signedBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return canonical, version.String(), nil
+}
+
+func parseDeviceKey(publicKeyPEM string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	switch publicKey.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return publicKey, nil
+	}
+
+	return nil, errors.New("public key must be an ECDSA or Ed25519 key")
+}
+
+func readDevice(ctx contractapi.TransactionContextInterface, keyID string) (*Device, error) {
+	deviceKey, err := ctx.GetStub().CreateCompositeKey(devicePrefix, []string{keyID})
+	if err != nil {
+		return nil, err
+	}
+	deviceBytes, err := ctx.GetStub().GetState(deviceKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device %s: %v", keyID, err)
+	}
+	if deviceBytes == nil {
+		return nil, nil
+	}
+
+	var device Device
+	err = json.Unmarshal(deviceBytes, &device)
+	if err != nil {
+		log.Printf("failed to json.Unmarshal value of device %s: %v", keyID, err)
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+func putDevice(ctx contractapi.TransactionContextInterface, device *Device) error {
+	deviceKey, err := ctx.GetStub().CreateCompositeKey(devicePrefix, []string{device.KeyID})
+	if err != nil {
+		return err
+	}
+	var deviceBytes []byte
+	deviceBytes, _ = json.Marshal(device)
+	err = ctx.GetStub().PutState(deviceKey, deviceBytes)
+	if err != nil {
+		return errors.New("unable to interact with world state")
+	}
+
+	return nil
+}
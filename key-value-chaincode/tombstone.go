@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Define key names for settings
+const tombstonePolicyKey = "tombstonePolicy"
+
+// Tombstone policies decide whether Create may reuse a soft-deleted key
+const (
+	TombstonePolicyReject = "reject"
+	TombstonePolicyReuse  = "reuse"
+)
+
+// Tombstone marks a soft-deleted record and keeps who deleted it and why
+type Tombstone struct {
+	DeletedBy    string    `json:"deletedBy"`
+	DeletedByMSP string    `json:"deletedByMSP"`
+	Reason       string    `json:"reason,omitempty" metadata:",optional"`
+	TxID         string    `json:"txId"`
+	DeletedAt    time.Time `json:"deletedAt"`
+}
+
+// SoftDelete replaces the value at key with a tombstone recording the deleter and reason
+func (sc *KeyValueContract) SoftDelete(ctx contractapi.TransactionContextInterface, key string, reason string) (string, error) {
+	event, err := softDeleteData(ctx, key, reason, map[string]bool{})
+	if err != nil {
+		return key, err
+	}
+
+	return key, emitRecordEvent(ctx, event)
+}
+
+// softDeleteData soft-deletes the record at key and, as their link types require, its children.
+// deleted holds the keys deleted so far in the transaction.
+func softDeleteData(ctx contractapi.TransactionContextInterface, key string, reason string, deleted map[string]bool) (*RecordEvent, error) {
+	recordData, err := readRecord(ctx, "", key)
+	if err != nil {
+		return nil, err
+	}
+	live, err := isLive(ctx, recordData)
+	if err != nil {
+		return nil, err
+	}
+	if !live {
+		return nil, fmt.Errorf("cannot delete world state pair with key %s. Does not exist", key)
+	}
+	err = checkAccessPolicy(ctx, "", AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	err = checkWriteAccess(ctx, key, recordData)
+	if err != nil {
+		return nil, err
+	}
+	deleted[key] = true
+	cascaded, err := cascadeDelete(ctx, key, false, reason, deleted)
+	if err != nil {
+		return nil, err
+	}
+
+	deleter, deleterMSP, err := clientIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deletedAt, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = updateRecordIndexes(ctx, "", key, recordData, nil)
+	if err != nil {
+		return nil, err
+	}
+	// PurgeExpired must not remove the tombstone and its audit data
+	err = updateExpiryIndex(ctx, "", key, recordData.ExpiresAt, "")
+	if err != nil {
+		return nil, err
+	}
+
+	recordData.Tombstone = &Tombstone{
+		DeletedBy:    deleter,
+		DeletedByMSP: deleterMSP,
+		Reason:       reason,
+		TxID:         ctx.GetStub().GetTxID(),
+		DeletedAt:    deletedAt,
+	}
+
+	err = storeRecord(ctx, key, recordData)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := newRecordEvent(ctx, RecordDeletedEvent, key, recordData, []string{"tombstone"})
+	if err != nil {
+		return nil, err
+	}
+	event.Cascaded = cascaded
+
+	return event, nil
+}
+
+// ReadTombstone returns the tombstone left at key by SoftDelete
+func (sc *KeyValueContract) ReadTombstone(ctx contractapi.TransactionContextInterface, key string) (*Tombstone, error) {
+	recordData, err := readRecord(ctx, "", key)
+	if err != nil {
+		return nil, err
+	}
+	if recordData == nil || recordData.Tombstone == nil {
+		return nil, fmt.Errorf("no tombstone at key %s", key)
+	}
+
+	return recordData.Tombstone, nil
+}
+
+// SetTombstonePolicy sets whether Create rejects or reuses soft-deleted keys
+func (sc *KeyValueContract) SetTombstonePolicy(ctx contractapi.TransactionContextInterface, policy string) error {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if policy != TombstonePolicyReject && policy != TombstonePolicyReuse {
+		return fmt.Errorf("unknown tombstone policy %s, must be %s or %s", policy, TombstonePolicyReject, TombstonePolicyReuse)
+	}
+
+	policyKey, err := ctx.GetStub().CreateCompositeKey(settingPrefix, []string{tombstonePolicyKey})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(policyKey, []byte(policy))
+}
+
+// GetTombstonePolicy returns the current tombstone policy, reject unless set otherwise
+func (sc *KeyValueContract) GetTombstonePolicy(ctx contractapi.TransactionContextInterface) (string, error) {
+	return tombstonePolicy(ctx)
+}
+
+func tombstonePolicy(ctx contractapi.TransactionContextInterface) (string, error) {
+	policyKey, err := ctx.GetStub().CreateCompositeKey(settingPrefix, []string{tombstonePolicyKey})
+	if err != nil {
+		return "", err
+	}
+	policyBytes, err := ctx.GetStub().GetState(policyKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read tombstone policy: %v", err)
+	}
+	if policyBytes == nil {
+		return TombstonePolicyReject, nil
+	}
+
+	return string(policyBytes), nil
+}
+
+// tombstoneReusable reports whether an existing record may be overwritten by Create
+func tombstoneReusable(ctx contractapi.TransactionContextInterface, existing *RecordData) (bool, error) {
+	if existing.Tombstone == nil {
+		return false, nil
+	}
+	policy, err := tombstonePolicy(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return policy == TombstonePolicyReuse, nil
+}
+
+func isTombstone(value []byte) bool {
+	var recordData RecordData
+	err := json.Unmarshal(value, &recordData)
+	if err != nil {
+		return false
+	}
+
+	return recordData.Tombstone != nil
+}
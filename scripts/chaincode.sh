@@ -51,6 +51,9 @@ peer lifecycle chaincode install ${CCNAME}.tar.gz
 EOF
 }
 
+# private data collections of the key-value chaincode, cloned by packageAndInstall
+COLLECTIONS_CONFIG=/go/hyperledger-fabric-v2-kubernetes-dev/key-value-chaincode/collections_config.json
+
 approve() {
   CCNAME=$1
   CHANNEL_ID=$2
@@ -61,6 +64,7 @@ PACKAGE_ID=\$(peer lifecycle chaincode queryinstalled | awk '/${LABEL}/ {print s
 echo "Package ID: \${PACKAGE_ID}"
 peer lifecycle chaincode approveformyorg --package-id \${PACKAGE_ID} \
   --signature-policy "AND('Org1MSP.member','Org2MSP.member','Org3MSP.member')" \
+  --collections-config ${COLLECTIONS_CONFIG} \
   -C ${CHANNEL_ID} -n ${CCNAME} -v 1.0  --sequence 1 \
   --tls true --cafile \$ORDERER_TLS_ROOTCERT_FILE --waitForEvent
 EOF
@@ -73,6 +77,7 @@ checkCommitReadiness() {
 peer lifecycle chaincode checkcommitreadiness \
 --name ${CCNAME} --channelID ${CHANNEL_ID} \
 --signature-policy "AND('Org1MSP.member', 'Org2MSP.member', 'Org3MSP.member')" \
+--collections-config ${COLLECTIONS_CONFIG} \
 --version 1.0 --sequence 1
 EOF
 }
@@ -87,6 +92,7 @@ peer lifecycle chaincode commit \
   --name ${CCNAME} \
   --version 1.0 \
   --signature-policy "AND('Org1MSP.member','Org2MSP.member', 'Org3MSP.member')" \
+  --collections-config ${COLLECTIONS_CONFIG} \
   --sequence 1 --waitForEvent \
   --peerAddresses peer0.org1:7051 \
   --peerAddresses peer0.org2:7051 \